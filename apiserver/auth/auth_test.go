@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTempFile writes data to a temporary file and returns its path.
+func writeTempFile(t *testing.T, data string) string {
+	f, err := ioutil.TempFile("", "heque-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	path := writeTempFile(t, "# key,secret,user,groups\nscheduler-1,s3cret,heque-scheduler,schedulers\n")
+	defer os.Remove(path)
+	a, err := NewHMACAuthenticatorFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/registry/jobs/evaluate_house?x=1", strings.NewReader(`{"payload":"1"}`))
+	if err := SignRequest(req, "scheduler-1", []byte("s3cret")); err != nil {
+		t.Fatal(err)
+	}
+	user, ok, err := a.AuthenticateRequest(req)
+	if err != nil || !ok || user.Name != "heque-scheduler" || user.Groups[0] != "schedulers" {
+		t.Fatalf("expected heque-scheduler, got %v %v %v", user, ok, err)
+	}
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != `{"payload":"1"}` {
+		t.Errorf("expected the body to be restored, got %q", body)
+	}
+
+	// the body is tampered with
+	tampered := httptest.NewRequest("POST", "/registry/jobs/evaluate_house?x=1", strings.NewReader(`{"payload":"2"}`))
+	tampered.Header = req.Header
+	if _, _, err := a.AuthenticateRequest(tampered); err != ErrInvalidCredentials {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+
+	// the request is replayed too late
+	req.Header.Set(HeaderDate, time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	if _, _, err := a.AuthenticateRequest(req); err != ErrInvalidCredentials {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	path := writeTempFile(t, fmt.Sprintf(`{"keys":[{"kty":"EC","kid":"k1","crv":"P-256","x":"%s","y":"%s"}]}`,
+		encode(key.X.Bytes()), encode(key.Y.Bytes())))
+	defer os.Remove(path)
+	a, err := NewJWTAuthenticatorFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Audience = "heque"
+
+	sign := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		signed := encode(header) + "." + encode(payload)
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// r and s padded to 32 bytes each
+		signature := make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+		return signed + "." + encode(signature)
+	}
+	authenticate := func(token string) (*User, bool, error) {
+		req := httptest.NewRequest("GET", "/registry/queues", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return a.AuthenticateRequest(req)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	user, ok, err := authenticate(sign(map[string]interface{}{"sub": "valuation-ui", "groups": []string{"ui"}, "aud": "heque", "exp": exp}))
+	if err != nil || !ok || user.Name != "valuation-ui" || user.Groups[0] != "ui" {
+		t.Fatalf("expected valuation-ui, got %v %v %v", user, ok, err)
+	}
+	if _, _, err := authenticate(sign(map[string]interface{}{"sub": "valuation-ui", "aud": "other", "exp": exp})); err != ErrInvalidCredentials {
+		t.Errorf("expected the audience to be checked, got %v", err)
+	}
+	if _, _, err := authenticate(sign(map[string]interface{}{"sub": "valuation-ui", "aud": "heque", "exp": time.Now().Add(-time.Hour).Unix()})); err != ErrInvalidCredentials {
+		t.Errorf("expected the expiry to be checked, got %v", err)
+	}
+	// a bearer token which is not a JWT is left to the other authenticators
+	if _, ok, err := authenticate("31ada4fd"); ok || err != nil {
+		t.Errorf("expected no credentials, got %v %v", ok, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	path := writeTempFile(t, `
+rules:
+- groups: [workers]
+  queues: [evaluate_house]
+  verbs: [dequeue, get, ack]
+- users: [valuation-ui]
+  queues: ["*"]
+  verbs: [enqueue, get, list, watch]
+`)
+	defer os.Remove(path)
+	p, err := LoadPolicyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker := &User{Name: "debtor-worker", Groups: []string{"workers"}}
+	ui := &User{Name: "valuation-ui"}
+	tests := []struct {
+		attrs   Attributes
+		allowed bool
+	}{
+		{Attributes{User: worker, Verb: VerbDequeue, Queue: "evaluate_house"}, true},
+		{Attributes{User: worker, Verb: VerbDequeue, Queue: "evaluate_debtor"}, false},
+		{Attributes{User: worker, Verb: VerbEnqueue, Queue: "evaluate_house"}, false},
+		{Attributes{User: worker, Verb: VerbList, Queue: ""}, false},
+		{Attributes{User: ui, Verb: VerbEnqueue, Queue: "evaluate_debtor"}, true},
+		{Attributes{User: ui, Verb: VerbWatch, Queue: ""}, true},
+		{Attributes{User: ui, Verb: VerbAck, Queue: "evaluate_house"}, false},
+	}
+	for _, test := range tests {
+		if got := p.Authorize(&test.attrs); got != test.allowed {
+			t.Errorf("%s %s %q: expected allowed %v, got %v", test.attrs.User.Name, test.attrs.Verb, test.attrs.Queue, test.allowed, got)
+		}
+	}
+
+	path = writeTempFile(t, "rules:\n- users: [a]\n  queues: [q]\n  verbs: [delete]\n")
+	defer os.Remove(path)
+	if _, err := LoadPolicyFile(path); err == nil {
+		t.Errorf("expected an unknown verb to be rejected")
+	}
+}
+
+func TestX509Authenticator(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "debtor-worker", Organization: []string{"workers"}}}
+	a := X509Authenticator{}
+	req := httptest.NewRequest("GET", "/registry/queues", nil)
+	if _, ok, err := a.AuthenticateRequest(req); ok || err != nil {
+		t.Errorf("expected no credentials without TLS, got %v %v", ok, err)
+	}
+
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if _, _, err := a.AuthenticateRequest(req); err != ErrInvalidCredentials {
+		t.Errorf("expected an unverified certificate to be invalid, got %v", err)
+	}
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	user, ok, err := a.AuthenticateRequest(req)
+	if err != nil || !ok || user.Name != "debtor-worker" || user.Groups[0] != "workers" {
+		t.Errorf("expected debtor-worker, got %v %v %v", user, ok, err)
+	}
+}
@@ -0,0 +1,5 @@
+// Package auth authenticates the requests of the apiserver, with static
+// bearer tokens, HMAC signatures, JWTs or client certificates, and
+// authorizes them with a policy mapping users and groups to the verbs they
+// may use on queues.
+package auth
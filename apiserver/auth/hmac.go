@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// hmacScheme is the scheme of the Authorization header of the signed
+	// requests.
+	hmacScheme = "HMAC-SHA256"
+	// HeaderDate is the header holding the time a request was signed at, in
+	// RFC 3339 format.
+	HeaderDate = "X-Heque-Date"
+	// DefaultMaxClockSkew is how far the time of a signed request may be
+	// from the time of the apiserver, so that it cannot be replayed later.
+	DefaultMaxClockSkew = 5 * time.Minute
+)
+
+type hmacKey struct {
+	secret []byte
+	user   *User
+}
+
+// HMACAuthenticator authenticates the requests signed with a secret key
+// shared with the apiserver, see SignRequest.
+type HMACAuthenticator struct {
+	keys map[string]*hmacKey
+	// MaxClockSkew is DefaultMaxClockSkew unless it is set.
+	MaxClockSkew time.Duration
+}
+
+// NewHMACAuthenticatorFromFile reads the keys of a CSV file, with a line per
+// key: the key ID, the secret, the user and then the groups of the user,
+// e.g.
+//
+//	scheduler-1,c2VjcmV0,heque-scheduler,schedulers
+func NewHMACAuthenticatorFromFile(path string) (*HMACAuthenticator, error) {
+	records, err := readCSV(path, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*hmacKey, len(records))
+	for _, r := range records {
+		if _, ok := keys[r[0]]; ok {
+			return nil, fmt.Errorf("%s: duplicate key %s", path, r[0])
+		}
+		keys[r[0]] = &hmacKey{secret: []byte(r[1]), user: &User{Name: r[2], Groups: r[3:]}}
+	}
+	return &HMACAuthenticator{keys: keys, MaxClockSkew: DefaultMaxClockSkew}, nil
+}
+
+// AuthenticateRequest implements Authenticator.
+func (a *HMACAuthenticator) AuthenticateRequest(req *http.Request) (*User, bool, error) {
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], hmacScheme) {
+		return nil, false, nil
+	}
+
+	var keyID, signature string
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return nil, false, ErrInvalidCredentials
+		}
+		switch kv[0] {
+		case "KeyId":
+			keyID = kv[1]
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+	key, ok := a.keys[keyID]
+	if !ok {
+		return nil, false, ErrInvalidCredentials
+	}
+
+	date, err := time.Parse(time.RFC3339, req.Header.Get(HeaderDate))
+	if err != nil {
+		return nil, false, ErrInvalidCredentials
+	}
+	maxSkew := a.MaxClockSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxClockSkew
+	}
+	if skew := time.Since(date); skew > maxSkew || skew < -maxSkew {
+		return nil, false, ErrInvalidCredentials
+	}
+
+	mac, err := requestMAC(req, key.secret)
+	if err != nil {
+		return nil, false, err
+	}
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, mac) {
+		return nil, false, ErrInvalidCredentials
+	}
+	return key.user, true, nil
+}
+
+// SignRequest signs req with the secret of the key keyID, for an
+// HMACAuthenticator. The body of req is read and replaced.
+func SignRequest(req *http.Request, keyID string, secret []byte) error {
+	req.Header.Set(HeaderDate, time.Now().UTC().Format(time.RFC3339))
+	mac, err := requestMAC(req, secret)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("%s KeyId=%s,Signature=%s",
+		hmacScheme, keyID, base64.StdEncoding.EncodeToString(mac)))
+	return nil
+}
+
+// requestMAC returns the MAC of the method, URI, date and body of req. The
+// body is read and replaced.
+func requestMAC(req *http.Request, secret []byte) ([]byte, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	bodyHash := sha256.Sum256(body)
+
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s", req.Method, req.URL.RequestURI(), req.Header.Get(HeaderDate), hex.EncodeToString(bodyHash[:]))
+	return h.Sum(nil), nil
+}
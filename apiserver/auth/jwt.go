@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew tolerated when checking the validity period of
+// a token.
+const jwtLeeway = time.Minute
+
+// JWTAuthenticator authenticates the requests with a JSON Web Token as
+// bearer token, signed with RS256 or ES256 by a key of a JSON Web Key Set.
+// The user is the "sub" claim of the token and the groups the "groups" one.
+type JWTAuthenticator struct {
+	// keys are the public keys by key ID.
+	keys map[string]crypto.PublicKey
+	// Issuer, if set, is the "iss" claim the tokens must have.
+	Issuer string
+	// Audience, if set, is one of the "aud" claims the tokens must have.
+	Audience string
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewJWTAuthenticatorFromFile reads the keys of a JSON Web Key Set file, the
+// RSA and the P-256 EC ones being used, the others ignored.
+func NewJWTAuthenticatorFromFile(path string) (*JWTAuthenticator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %v", path, jwk.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		if _, ok := keys[jwk.Kid]; ok {
+			return nil, fmt.Errorf("%s: duplicate key %q", path, jwk.Kid)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no RSA or P-256 signing key", path)
+	}
+	return &JWTAuthenticator{keys: keys}, nil
+}
+
+// publicKey returns the public key of jwk, or nil if its type is not
+// supported.
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Groups    []string    `json:"groups"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt *int64      `json:"exp"`
+	NotBefore *int64      `json:"nbf"`
+}
+
+// jwtAudience is the "aud" claim, a string or an array of strings.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*a = jwtAudience{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// AuthenticateRequest implements Authenticator. A bearer token which is not
+// a JWT is left to the other authenticators.
+func (a *JWTAuthenticator) AuthenticateRequest(req *http.Request) (*User, bool, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, false, nil
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false, nil
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, false, nil
+	}
+	key, ok := a.keys[header.Kid]
+	if !ok {
+		return nil, false, ErrInvalidCredentials
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, false, ErrInvalidCredentials
+	}
+	if !verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature) {
+		return nil, false, ErrInvalidCredentials
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, false, ErrInvalidCredentials
+	}
+	if err := a.validate(&claims, time.Now()); err != nil {
+		return nil, false, err
+	}
+	return &User{Name: claims.Subject, Groups: claims.Groups}, true, nil
+}
+
+// validate checks the validity period, the issuer and the audience of
+// claims.
+func (a *JWTAuthenticator) validate(claims *jwtClaims, now time.Time) error {
+	if claims.Subject == "" {
+		return ErrInvalidCredentials
+	}
+	if claims.ExpiresAt == nil || now.Add(-jwtLeeway).Unix() >= *claims.ExpiresAt {
+		return ErrInvalidCredentials
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Unix() < *claims.NotBefore {
+		return ErrInvalidCredentials
+	}
+	if a.Issuer != "" && claims.Issuer != a.Issuer {
+		return ErrInvalidCredentials
+	}
+	if a.Audience != "" {
+		for _, aud := range claims.Audience {
+			if aud == a.Audience {
+				return nil
+			}
+		}
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// verifySignature reports whether signature is the signature of signed with
+// key by the algorithm alg, RS256 or ES256.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
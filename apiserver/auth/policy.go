@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"sigs.k8s.io/yaml"
+)
+
+// These are the verbs of the requests, see Attributes.
+const (
+	VerbEnqueue = "enqueue"
+	VerbDequeue = "dequeue"
+	VerbGet     = "get"
+	// VerbAck covers acknowledging, failing and heartbeating a running job.
+	VerbAck   = "ack"
+	VerbList  = "list"
+	VerbWatch = "watch"
+)
+
+var verbs = map[string]bool{
+	VerbEnqueue: true,
+	VerbDequeue: true,
+	VerbGet:     true,
+	VerbAck:     true,
+	VerbList:    true,
+	VerbWatch:   true,
+}
+
+// Attributes are what a request does, to be authorized.
+type Attributes struct {
+	User *User
+	Verb string
+	// Queue is the queue of the request, or empty if the request is not
+	// about a single queue, e.g. reading a batch or listing the queues.
+	Queue string
+}
+
+// Authorizer authorizes requests.
+type Authorizer interface {
+	// Authorize reports whether a request with attrs is allowed.
+	Authorize(attrs *Attributes) bool
+}
+
+// AlwaysAllow allows every request.
+type AlwaysAllow struct{}
+
+// Authorize implements Authorizer.
+func (AlwaysAllow) Authorize(*Attributes) bool { return true }
+
+// Rule allows users and groups to use verbs on queues. "*" matches every
+// user, group, queue or verb, and is the only queue matching the requests
+// which are not about a single queue.
+type Rule struct {
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Queues []string `json:"queues"`
+	Verbs  []string `json:"verbs"`
+}
+
+// Policy allows the requests matching one of its rules at least, e.g.
+//
+//	rules:
+//	- groups: [workers]
+//	  queues: [evaluate_house]
+//	  verbs: [dequeue, get, ack]
+//	- users: [valuation-ui]
+//	  queues: ["*"]
+//	  verbs: [enqueue, get, list, watch]
+type Policy struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// LoadPolicyFile reads and validates the policy of a YAML or JSON file.
+func LoadPolicyFile(path string) (*Policy, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.UnmarshalStrict(buf, &p); err != nil {
+		return nil, err
+	}
+
+	for i, r := range p.Rules {
+		if len(r.Users) == 0 && len(r.Groups) == 0 {
+			return nil, fmt.Errorf("%s: rule %d has no users or groups", path, i+1)
+		}
+		if len(r.Queues) == 0 {
+			return nil, fmt.Errorf("%s: rule %d has no queues", path, i+1)
+		}
+		if len(r.Verbs) == 0 {
+			return nil, fmt.Errorf("%s: rule %d has no verbs", path, i+1)
+		}
+		for _, verb := range r.Verbs {
+			if verb != "*" && !verbs[verb] {
+				return nil, fmt.Errorf("%s: rule %d has unknown verb %q", path, i+1, verb)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Authorize implements Authorizer.
+func (p *Policy) Authorize(attrs *Attributes) bool {
+	if attrs.User == nil {
+		return false
+	}
+	for _, r := range p.Rules {
+		if r.matches(attrs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matches(attrs *Attributes) bool {
+	if !contains(r.Verbs, attrs.Verb) {
+		return false
+	}
+	if attrs.Queue == "" {
+		if !contains(r.Queues, "*") {
+			return false
+		}
+	} else if !contains(r.Queues, attrs.Queue) {
+		return false
+	}
+
+	if contains(r.Users, attrs.User.Name) {
+		return true
+	}
+	for _, group := range attrs.User.Groups {
+		if contains(r.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether list has s or "*".
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s || item == "*" {
+			return true
+		}
+	}
+	return false
+}
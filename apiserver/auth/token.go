@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TokenAuthenticator authenticates the requests with a bearer token in
+// their Authorization header, e.g. "Authorization: Bearer 31ada4fd".
+type TokenAuthenticator struct {
+	tokens map[string]*User
+}
+
+// NewTokenAuthenticatorFromFile reads the tokens of a CSV file, with a line
+// per token: the token, the user and then the groups of the user, e.g.
+//
+//	31ada4fd,valuation-ui,ui
+//	85f0c2e1,debtor-worker,workers,debtor
+func NewTokenAuthenticatorFromFile(path string) (*TokenAuthenticator, error) {
+	records, err := readCSV(path, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]*User, len(records))
+	for _, r := range records {
+		if _, ok := tokens[r[0]]; ok {
+			return nil, fmt.Errorf("%s: duplicate token of user %s", path, r[1])
+		}
+		tokens[r[0]] = &User{Name: r[1], Groups: r[2:]}
+	}
+	return &TokenAuthenticator{tokens: tokens}, nil
+}
+
+// AuthenticateRequest implements Authenticator.
+func (a *TokenAuthenticator) AuthenticateRequest(req *http.Request) (*User, bool, error) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, false, nil
+	}
+
+	for t, user := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return user, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// bearerToken returns the bearer token of the Authorization header of req.
+func bearerToken(req *http.Request) (string, bool) {
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return "", false
+	}
+	token := strings.TrimSpace(parts[1])
+	return token, token != ""
+}
+
+// readCSV reads the records of a CSV file, which have min fields at least.
+// Empty lines and lines starting with # are skipped.
+func readCSV(path string, min int) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if len(record) < min {
+			return nil, fmt.Errorf("%s: record %d has %d fields, %d at least are expected", path, i+1, len(record), min)
+		}
+	}
+	return records, nil
+}
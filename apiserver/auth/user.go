@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrInvalidCredentials is returned by an Authenticator when a request has
+// credentials of its kind which are not valid.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// User is the identity of an authenticated request.
+type User struct {
+	Name   string
+	Groups []string
+}
+
+// Authenticator authenticates requests.
+type Authenticator interface {
+	// AuthenticateRequest returns the user of req. It reports false if req
+	// has no credentials of the kind of the Authenticator, and returns an
+	// error if they are not valid.
+	AuthenticateRequest(req *http.Request) (*User, bool, error)
+}
+
+// Union authenticates a request with the first Authenticator finding
+// credentials of its kind in it.
+type Union []Authenticator
+
+// AuthenticateRequest implements Authenticator.
+func (u Union) AuthenticateRequest(req *http.Request) (*User, bool, error) {
+	for _, a := range u {
+		user, ok, err := a.AuthenticateRequest(req)
+		if ok || err != nil {
+			return user, ok, err
+		}
+	}
+	return nil, false, nil
+}
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx carrying user.
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the user of ctx, or nil if it has none.
+func UserFrom(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey{}).(*User)
+	return user
+}
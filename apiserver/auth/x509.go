@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// X509Authenticator authenticates the requests with the client certificate
+// of their TLS connection, verified by the server against its client CAs.
+// The user is the common name of the certificate and the groups its
+// organizations, e.g. "/O=workers/CN=debtor-worker".
+type X509Authenticator struct{}
+
+// AuthenticateRequest implements Authenticator.
+func (X509Authenticator) AuthenticateRequest(req *http.Request) (*User, bool, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false, nil
+	}
+	if len(req.TLS.VerifiedChains) == 0 {
+		return nil, false, ErrInvalidCredentials
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, false, ErrInvalidCredentials
+	}
+	return &User{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization}, true, nil
+}
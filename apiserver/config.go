@@ -1,13 +1,77 @@
 package apiserver
 
-import "go.etcd.io/etcd/clientv3"
+import (
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+
+	"denggotech.cn/heque/heque/apiserver/auth"
+	"denggotech.cn/heque/heque/client"
+)
+
+// These are the valid storage backends of the jobs.
+const (
+	// StorageBackendRedis stores the jobs in the redis queues shared with
+	// the workers, see package client.
+	StorageBackendRedis = "redis"
+	// StorageBackendETCD stores the jobs in etcd.
+	StorageBackendETCD = "etcd"
+)
 
 // Config is a structure used to configure an APIServer.
 type Config struct {
+	// StorageBackend is where the jobs are stored, StorageBackendRedis or
+	// StorageBackendETCD.
+	StorageBackend string
 	// Prefix is the prefix of queue
 	Prefix string
 	// ETCDServers is List of etcd servers to connect with (ip:port), comma separated.
 	ETCDServers []string
-	// Storage is client of etcd
+	// Storage is client of etcd, for StorageBackendETCD
 	Storage *clientv3.Client
+	// Client is the heque client of the redis queues shared with the workers,
+	// used by the redis storage backend.
+	Client *client.Client
+	// MaxDequeueWaiters is the number of dequeue requests waiting for a job
+	// at the same time, see handlers.WaitLimiter.
+	MaxDequeueWaiters int
+	// MaxDequeueWait is the longest wait of a dequeue request.
+	MaxDequeueWait time.Duration
+	// MaxBatchWatchers is the number of batch streams open at the same
+	// time, see handlers.WatchLimiter.
+	MaxBatchWatchers int
+	// LeaseTTL is how long a job dequeued over HTTP is held by its consumer
+	// without a heartbeat.
+	LeaseTTL time.Duration
+
+	// The filters of the handler chain, see buildHandlerChain.
+	EnableCompression   bool
+	EnableCORS          bool
+	EnablePanicRecovery bool
+	EnableRequestID     bool
+	EnableAccessLog     bool
+	// MaxRequestBodyBytes limits the size of the request bodies, unless it
+	// is zero.
+	MaxRequestBodyBytes int64
+	// RequestTimeout bounds the requests which do not stream or wait for a
+	// job, unless it is zero.
+	RequestTimeout time.Duration
+	// TLSCertFile and TLSPrivateKeyFile are the PEM files of the certificate
+	// of the server, which serves HTTPS if they are set. They are reloaded
+	// when they change.
+	TLSCertFile       string
+	TLSPrivateKeyFile string
+	// ClientCAFile, if set, is the PEM file of the CAs verifying the client
+	// certificates, see auth.X509Authenticator. It is reloaded when it
+	// changes.
+	ClientCAFile string
+	// Authenticator authenticates the requests, which are all allowed
+	// without credentials if it is nil.
+	Authenticator auth.Authenticator
+	// Authorizer authorizes the authenticated requests, which are all
+	// allowed if it is nil.
+	Authorizer auth.Authorizer
+	// ShutdownTimeout is how long the requests in progress are waited for
+	// on shutdown, before their connections are closed.
+	ShutdownTimeout time.Duration
 }
@@ -0,0 +1,56 @@
+package filters
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WithAccessLog wraps an http Handler to log every request once it is
+// served, with its status and duration.
+func WithAccessLog(handler http.Handler) http.Handler {
+	logger := zap.L().Named("access")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			logger.Info("served request",
+				zap.String("method", req.Method),
+				zap.String("uri", req.RequestURI),
+				zap.String("remote", req.RemoteAddr),
+				zap.String("requestID", RequestIDFrom(req.Context())),
+				zap.Int("status", rw.status),
+				zap.Int("bytes", rw.written),
+				zap.Duration("duration", time.Since(start)),
+			)
+		}()
+
+		handler.ServeHTTP(rw, req)
+	})
+}
+
+// responseRecorder records the status and the size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.written += n
+	return n, err
+}
+
+// Flush implements http.Flusher, for the streamed responses.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,29 @@
+package filters
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/apiserver/auth"
+)
+
+// WithAuthentication wraps an http Handler to authenticate every request,
+// adding its user to the context of the request, see auth.UserFrom. The
+// requests without valid credentials are turned down with 401.
+func WithAuthentication(handler http.Handler, authenticator auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, ok, err := authenticator.AuthenticateRequest(req)
+		if err != nil && err != auth.ErrInvalidCredentials {
+			zap.L().Error("failed to authenticate request",
+				zap.String("requestID", RequestIDFrom(req.Context())), zap.Error(err))
+		}
+		if !ok || err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="heque"`)
+			http.Error(w, "apiserver: unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, req.WithContext(auth.WithUser(req.Context(), user)))
+	})
+}
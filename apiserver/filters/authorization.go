@@ -0,0 +1,27 @@
+package filters
+
+import (
+	"net/http"
+
+	"denggotech.cn/heque/heque/apiserver/auth"
+)
+
+// AttributesFunc returns the verb and the queue of a request, see
+// auth.Attributes.
+type AttributesFunc func(req *http.Request) (verb string, queue string)
+
+// WithAuthorization wraps an http Handler to authorize every request of the
+// user of its context with authorizer. The requests which are not allowed
+// are turned down with 403.
+func WithAuthorization(handler http.Handler, authorizer auth.Authorizer, attributes AttributesFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		verb, queue := attributes(req)
+		attrs := &auth.Attributes{User: auth.UserFrom(req.Context()), Verb: verb, Queue: queue}
+		if !authorizer.Authorize(attrs) {
+			http.Error(w, "apiserver: forbidden", http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -38,6 +38,9 @@ func WithCompression(handler http.Handler) http.Handler {
 				return
 			}
 			compressionWriter.Header().Set("Content-Encoding", encoding)
+			// the response is compressed here, and must not be compressed
+			// by the handler as well, e.g. the one of the metrics
+			r.Header.Del(headerAcceptEncoding)
 			handler.ServeHTTP(compressionWriter, r)
 			compressionWriter.(*compressionResponseWriter).Close()
 		} else {
@@ -133,6 +136,10 @@ func (c *compressionResponseWriter) Flush() {
 		return
 	}
 	c.compressor.Flush()
+	// send the compressed data, e.g. of the streamed responses
+	if f, ok := c.writer.(http.Flusher); ok {
+		f.Flush()
+	}
 }
 
 func (c *compressionResponseWriter) compressorClosed() bool {
@@ -0,0 +1,19 @@
+package filters
+
+import (
+	"net/http"
+)
+
+// WithMaxRequestBodyBytes wraps an http Handler to fail the reading of
+// request bodies larger than n bytes.
+func WithMaxRequestBodyBytes(handler http.Handler, n int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > n {
+			http.Error(w, "apiserver: request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, n)
+
+		handler.ServeHTTP(w, req)
+	})
+}
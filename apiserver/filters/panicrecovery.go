@@ -3,7 +3,7 @@ package filters
 import (
 	"net/http"
 
-	"github.com/golang/glog"
+	"go.uber.org/zap"
 
 	utilruntime "denggotech.cn/heque/heque/util/runtime"
 )
@@ -12,7 +12,7 @@ import (
 func WithPanicRecovery(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer utilruntime.HandleCrash(func(err interface{}) {
-			glog.Errorf("servlet panic'd on %v %v", r.Method, r.RequestURI)
+			zap.L().Error("servlet panic'd", zap.String("method", r.Method), zap.String("uri", r.RequestURI))
 			http.Error(w, "This request caused servlet to panic. Look in the logs for details.", http.StatusInternalServerError)
 		})
 		// Dispatch to the internal handler
@@ -0,0 +1,34 @@
+package filters
+
+import (
+	"context"
+	"net/http"
+
+	"denggotech.cn/heque/heque/util/uuid"
+)
+
+// headerRequestID is the header carrying the ID of a request, set by the
+// client or else generated.
+const headerRequestID = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID wraps an http Handler to give every request an ID, which is
+// added to the context of the request and echoed in the response.
+func WithRequestID(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(headerRequestID)
+		if id == "" {
+			id = uuid.NewUUID()
+		}
+		w.Header().Set(headerRequestID, id)
+
+		handler.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFrom returns the ID of the request of ctx, or "" if it has none.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
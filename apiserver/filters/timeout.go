@@ -0,0 +1,24 @@
+package filters
+
+import (
+	"net/http"
+	"time"
+)
+
+// LongRunningFunc reports whether a request is long-running, such as a
+// stream or a long-poll, and may exceed the timeout of the requests.
+type LongRunningFunc func(req *http.Request) bool
+
+// WithTimeout wraps an http Handler to reply 503 to the requests which are
+// not served within timeout, except the long-running ones. The responses of
+// the other requests are buffered until they are served.
+func WithTimeout(handler http.Handler, timeout time.Duration, longRunning LongRunningFunc) http.Handler {
+	timeoutHandler := http.TimeoutHandler(handler, timeout, "apiserver: request timed out")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if longRunning(req) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		timeoutHandler.ServeHTTP(w, req)
+	})
+}
@@ -1 +1,89 @@
 package apiserver
+
+import (
+	"net/http"
+	"strings"
+
+	"denggotech.cn/heque/heque/apiserver/auth"
+	"denggotech.cn/heque/heque/apiserver/filters"
+)
+
+// buildHandlerChain wraps handler with the filters enabled by cfg. A request
+// goes through them in this order: request ID, access log, panic recovery,
+// CORS, timeout, body size limit, authentication, authorization and
+// compression.
+func buildHandlerChain(handler http.Handler, cfg *Config) http.Handler {
+	if cfg.EnableCompression {
+		handler = filters.WithCompression(handler)
+	}
+	if cfg.Authenticator != nil {
+		if cfg.Authorizer != nil {
+			handler = filters.WithAuthorization(handler, cfg.Authorizer, requestAttributes)
+		}
+		handler = filters.WithAuthentication(handler, cfg.Authenticator)
+	}
+	if cfg.MaxRequestBodyBytes > 0 {
+		handler = filters.WithMaxRequestBodyBytes(handler, cfg.MaxRequestBodyBytes)
+	}
+	if cfg.RequestTimeout > 0 {
+		handler = filters.WithTimeout(handler, cfg.RequestTimeout, isLongRunning)
+	}
+	if cfg.EnableCORS {
+		handler = filters.WithCORS(handler)
+	}
+	if cfg.EnablePanicRecovery {
+		handler = filters.WithPanicRecovery(handler)
+	}
+	if cfg.EnableAccessLog {
+		handler = filters.WithAccessLog(handler)
+	}
+	if cfg.EnableRequestID {
+		handler = filters.WithRequestID(handler)
+	}
+	return handler
+}
+
+// isLongRunning reports whether req streams a batch, or waits for a job.
+func isLongRunning(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/watch") || req.URL.Query().Get("wait") != ""
+}
+
+// requestAttributes returns the verb and the queue of req, from its route,
+// see NewAPIServer. The queue is empty for the batches, the list of the
+// queues and the metrics.
+func requestAttributes(req *http.Request) (string, string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "registry" {
+		return auth.VerbGet, ""
+	}
+
+	switch parts[1] {
+	case "jobs":
+		if len(parts) < 3 {
+			break
+		}
+		queue := parts[2]
+		switch {
+		case len(parts) == 3 && req.Method == http.MethodPost:
+			return auth.VerbEnqueue, queue
+		case len(parts) == 3:
+			return auth.VerbDequeue, queue
+		case len(parts) == 4:
+			return auth.VerbGet, queue
+		default:
+			// ack, fail and heartbeat
+			return auth.VerbAck, queue
+		}
+	case "batches":
+		if parts[len(parts)-1] == "watch" {
+			return auth.VerbWatch, ""
+		}
+		return auth.VerbGet, ""
+	case "queues":
+		if len(parts) > 2 {
+			return auth.VerbList, parts[2]
+		}
+		return auth.VerbList, ""
+	}
+	return auth.VerbGet, ""
+}
@@ -6,17 +6,24 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"path"
 	"strconv"
 	"time"
 
 	restful "github.com/emicklei/go-restful/v3"
 	"go.etcd.io/etcd/clientv3"
 	v3 "go.etcd.io/etcd/clientv3"
+	"go.uber.org/zap"
+
+	heque "denggotech.cn/heque/heque/client"
+	utilhttp "denggotech.cn/heque/heque/util/http"
+	utilruntime "denggotech.cn/heque/heque/util/runtime"
 )
 
 var ErrKeyExists = errors.New("key exists")
 
-// EnqueueJobHandler enqueue a job into specified queue.
+// EnqueueJobHandler enqueue a job into specified queue, and replies 201 with
+// the job.
 func EnqueueJobHandler(client *clientv3.Client, prefix string, request *restful.Request, response *restful.Response) {
 	body, err := ioutil.ReadAll(request.Request.Body)
 	if err != nil {
@@ -24,48 +31,70 @@ func EnqueueJobHandler(client *clientv3.Client, prefix string, request *restful.
 		return
 	}
 	qName := request.PathParameter("queue")
-	jobID, err := newUniqueKV(client, prefix, qName, string(body))
+	jobID, err := newUniqueKV(client, queuePrefix(prefix, qName), string(body))
 	if err != nil {
-		http.Error(response, "apiserver: error enqueue", http.StatusBadRequest)
+		zap.L().Error("failed to enqueue job", zap.String("queue", qName), zap.Error(err))
+		http.Error(response, "apiserver: error enqueue", http.StatusInternalServerError)
 		return
 	}
-	fmt.Println(jobID)
+	zap.L().Debug("enqueued job", zap.String("job", jobID), zap.String("queue", qName))
+
+	job := &Job{ID: jobID, Queue: qName, Phase: string(heque.JobPending)}
+	utilruntime.HandleError(utilhttp.JSON(response, job, http.StatusCreated))
 }
 
-// DequeueJobHandler enqueue a job into specified queue.
-func DequeueJobHandler(client *clientv3.Client, prefix string, request *restful.Request, response *restful.Response) {
+// DequeueJobHandler removes the oldest job of the specified queue, and
+// replies with the job, or with 204 if the queue is empty. With the wait
+// query parameter, it waits for a job to be enqueued into an empty queue
+// until the timeout.
+func DequeueJobHandler(client *clientv3.Client, prefix string, waiters *WaitLimiter, request *restful.Request, response *restful.Response) {
+	ctx, wait, done, ok := waiters.start(request, response)
+	if !ok {
+		return
+	}
+	defer done()
+
 	queue := request.PathParameter("queue")
-	key := fmt.Sprintf("%s/jobs/%s", prefix, queue)
-	// count keys about to be deleted
-	resp, err := client.Get(context.TODO(), key, clientv3.WithPrefix(), clientv3.WithLimit(1))
+	key, value, err := waitKV(ctx, client, queuePrefix(prefix, queue), wait)
 	if err != nil {
-		http.Error(response, "apiserver: error enqueue", http.StatusBadRequest)
+		zap.L().Error("failed to dequeue job", zap.String("queue", queue), zap.Error(err))
+		http.Error(response, "apiserver: error dequeue", http.StatusInternalServerError)
+		return
 	}
-	if resp.Kvs == nil || len(resp.Kvs) == 0 {
-		http.Error(response, "apiserver: error dequeue", http.StatusNoContent)
+	if key == "" && ctx.Err() != nil {
+		writeAbandoned(response)
+		return
+	}
+	if key == "" {
+		response.WriteHeader(http.StatusNoContent)
+		return
 	}
-	deleteKey := string(resp.Kvs[0].Key)
 
-	// delete the keys
-	_, err = client.Delete(context.TODO(), deleteKey, clientv3.WithPrefix())
-	if err != nil {
-		http.Error(response, "apiserver: error dequeue", http.StatusInternalServerError)
+	job := &Job{
+		ID:      path.Base(key),
+		Queue:   queue,
+		Payload: value,
+		Phase:   string(heque.JobRunning),
 	}
-	response.Write(resp.Kvs[0].Value)
+	utilruntime.HandleError(utilhttp.JSON(response, job, http.StatusOK))
 }
 
-func newUniqueKV(kv v3.KV, prefix string, queue string, job string) (string, error) {
+// queuePrefix returns the prefix of the keys of the jobs of queue.
+func queuePrefix(prefix string, queue string) string {
+	return fmt.Sprintf("%s/jobs/%s/", prefix, queue)
+}
+
+func newUniqueKV(kv v3.KV, prefix string, job string) (string, error) {
 	for {
 		jobID := strconv.FormatInt(time.Now().UnixNano(), 10)
-		newKey := fmt.Sprintf("%s/jobs/%s/%s", prefix, queue, jobID)
 		// TODO: 后期添加添加过期时间
-		_, err := putNewKV(kv, newKey, job, v3.NoLease)
+		_, err := putNewKV(kv, prefix+jobID, job, v3.NoLease)
+		if err == ErrKeyExists {
+			continue
+		}
 		if err != nil {
 			return "", err
 		}
-		if err != ErrKeyExists {
-			return "", nil
-		}
 		return jobID, nil
 	}
 }
@@ -84,3 +113,66 @@ func putNewKV(kv v3.KV, key, val string, leaseID v3.LeaseID) (int64, error) {
 	}
 	return txnresp.Header.Revision, nil
 }
+
+// dequeueKV deletes the oldest key under prefix and returns it with its
+// value. The key is deleted only if it has not changed since it was read, so
+// that every key is returned to a single consumer. If there is no key, it
+// returns an empty key and the revision the prefix was read at.
+func dequeueKV(ctx context.Context, kv v3.KV, prefix string) (string, string, int64, error) {
+	for {
+		resp, err := kv.Get(ctx, prefix, v3.WithFirstCreate()...)
+		if err != nil {
+			return "", "", 0, err
+		}
+		if len(resp.Kvs) == 0 {
+			return "", "", resp.Header.Revision, nil
+		}
+
+		first := resp.Kvs[0]
+		key := string(first.Key)
+		txnresp, err := kv.Txn(ctx).
+			If(v3.Compare(v3.ModRevision(key), "=", first.ModRevision)).
+			Then(v3.OpDelete(key)).
+			Commit()
+		if err != nil {
+			return "", "", 0, err
+		}
+		if txnresp.Succeeded {
+			return key, string(first.Value), 0, nil
+		}
+		// taken by another consumer, try the next key
+	}
+}
+
+// waitKV is like dequeueKV, but if there is no key under prefix, it watches
+// the prefix for a new key until wait has elapsed or ctx is done, in which
+// case it returns an empty key.
+func waitKV(ctx context.Context, client *clientv3.Client, prefix string, wait time.Duration) (string, string, error) {
+	if wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wait)
+		defer cancel()
+	}
+
+	for {
+		key, value, rev, err := dequeueKV(ctx, client, prefix)
+		if err != nil && ctx.Err() != nil {
+			return "", "", nil
+		}
+		if err != nil || key != "" || wait <= 0 {
+			return key, value, err
+		}
+
+		// the keys put since the prefix was read, so that none is missed
+		watchCtx, cancel := context.WithCancel(ctx)
+		resp, ok := <-client.Watch(watchCtx, prefix, v3.WithPrefix(), v3.WithRev(rev+1), v3.WithFilterDelete())
+		cancel()
+		if ctx.Err() != nil {
+			return "", "", nil
+		}
+		if ok && resp.Err() != nil {
+			return "", "", resp.Err()
+		}
+		// a key was put, which may be taken by another consumer first
+	}
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+)
+
+const testPrefix = "/registry"
+
+// startETCD starts an embedded etcd server and returns a client of it, and
+// a function stopping the server.
+func startETCD(t *testing.T) (*clientv3.Client, func()) {
+	dir, err := ioutil.TempDir("", "heque-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogOutput = "stderr"
+	urls := freeURLs(t, 2)
+	cfg.LCUrls = urls[:1]
+	cfg.ACUrls = cfg.LCUrls
+	cfg.LPUrls = urls[1:]
+	cfg.APUrls = cfg.LPUrls
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	stop := func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		stop()
+		t.Fatal("etcd took too long to start")
+	}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{cfg.LCUrls[0].String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	return cli, func() {
+		cli.Close()
+		stop()
+	}
+}
+
+// freeURLs returns the URLs of n distinct free ports.
+func freeURLs(t *testing.T, n int) []url.URL {
+	var urls []url.URL
+	for i := 0; i < n; i++ {
+		// kept open until all the ports are picked, which are distinct then
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		urls = append(urls, url.URL{Scheme: "http", Host: l.Addr().String()})
+	}
+	return urls
+}
+
+func newETCDServer(cli *clientv3.Client) *httptest.Server {
+	waiters := NewWaitLimiter(2, 5*time.Second)
+	ws := new(restful.WebService)
+	ws.Route(ws.POST("/registry/jobs/{queue}").To(func(req *restful.Request, res *restful.Response) {
+		EnqueueJobHandler(cli, testPrefix, req, res)
+	}))
+	ws.Route(ws.GET("/registry/jobs/{queue}").To(func(req *restful.Request, res *restful.Response) {
+		DequeueJobHandler(cli, testPrefix, waiters, req, res)
+	}))
+	container := restful.NewContainer()
+	container.Add(ws)
+
+	return httptest.NewServer(container)
+}
+
+func enqueue(t *testing.T, srv *httptest.Server, queue string, payload string) *Job {
+	res, err := http.Post(srv.URL+"/registry/jobs/"+queue, "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", res.StatusCode)
+	}
+
+	var job Job
+	if err := json.NewDecoder(res.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == "" {
+		t.Fatalf("expected the ID of the job")
+	}
+	return &job
+}
+
+// dequeue returns the dequeued job, or nil if the queue is empty.
+func dequeue(t *testing.T, srv *httptest.Server, queue string) *Job {
+	res, err := http.Get(srv.URL + "/registry/jobs/" + queue)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", res.StatusCode)
+		return nil
+	}
+
+	var job Job
+	if err := json.NewDecoder(res.Body).Decode(&job); err != nil {
+		t.Error(err)
+		return nil
+	}
+	return &job
+}
+
+func TestETCDEnqueueDequeue(t *testing.T) {
+	cli, stop := startETCD(t)
+	defer stop()
+	srv := newETCDServer(cli)
+	defer srv.Close()
+
+	if job := dequeue(t, srv, "q"); job != nil {
+		t.Fatalf("expected an empty queue, got %+v", job)
+	}
+
+	first := enqueue(t, srv, "q", `{"n": 1}`)
+	second := enqueue(t, srv, "q", `{"n": 2}`)
+	// a queue whose name starts with the name of the other one
+	enqueue(t, srv, "q2", `{"n": 3}`)
+
+	for _, want := range []*Job{first, second} {
+		got := dequeue(t, srv, "q")
+		if got == nil || got.ID != want.ID {
+			t.Fatalf("expected job %s, got %+v", want.ID, got)
+		}
+	}
+	if job := dequeue(t, srv, "q"); job != nil {
+		t.Errorf("expected an empty queue, got %+v", job)
+	}
+}
+
+func TestETCDConcurrentDequeue(t *testing.T) {
+	cli, stop := startETCD(t)
+	defer stop()
+	srv := newETCDServer(cli)
+	defer srv.Close()
+
+	const jobs = 20
+	for i := 0; i < jobs; i++ {
+		enqueue(t, srv, "q", fmt.Sprintf(`{"n": %d}`, i))
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := dequeue(t, srv, "q"); job != nil; job = dequeue(t, srv, "q") {
+				mu.Lock()
+				seen[job.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != jobs {
+		t.Errorf("expected %d jobs to be dequeued, got %d", jobs, len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("job %s dequeued %d times", id, n)
+		}
+	}
+}
+
+func TestETCDWaitDequeue(t *testing.T) {
+	cli, stop := startETCD(t)
+	defer stop()
+	srv := newETCDServer(cli)
+	defer srv.Close()
+
+	start := time.Now()
+	if job := dequeue(t, srv, "q?wait=500ms"); job != nil {
+		t.Fatalf("expected an empty queue, got %+v", job)
+	}
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms, waited %s", d)
+	}
+
+	done := make(chan *Job)
+	go func() {
+		done <- dequeue(t, srv, "q?wait=5s")
+	}()
+	time.Sleep(200 * time.Millisecond)
+	want := enqueue(t, srv, "q", `{"n": 1}`)
+
+	select {
+	case got := <-done:
+		if got == nil || got.ID != want.ID {
+			t.Errorf("expected job %s, got %+v", want.ID, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the waiting dequeue did not return the enqueued job")
+	}
+}
+
+func TestETCDTooManyWaiters(t *testing.T) {
+	cli, stop := startETCD(t)
+	defer stop()
+	srv := newETCDServer(cli)
+	defer srv.Close()
+
+	// newETCDServer allows two waiters
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dequeue(t, srv, "q?wait=1s")
+		}()
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	res, err := http.Get(srv.URL + "/registry/jobs/q?wait=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", res.StatusCode)
+	}
+	wg.Wait()
+}
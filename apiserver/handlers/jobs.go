@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/apiserver/auth"
+	"denggotech.cn/heque/heque/client"
+	utilhttp "denggotech.cn/heque/heque/util/http"
+	utilruntime "denggotech.cn/heque/heque/util/runtime"
+)
+
+// JobSpec is the representation of client.JobSpec in the REST API.
+type JobSpec struct {
+	Payload string `json:"payload"`
+	// Queue is the queue of a follow-up job. The queue of an enqueued job
+	// is given by the path.
+	Queue      string   `json:"queue,omitempty"`
+	Batch      string   `json:"batch"`
+	Timeout    string   `json:"timeout,omitempty"`
+	MaxRetries int      `json:"maxRetries,omitempty"`
+	ResultTTL  string   `json:"resultTTL,omitempty"`
+	DependsOn  []string `json:"dependsOn,omitempty"`
+	LockKey    string   `json:"lockKey,omitempty"`
+	OnSuccess  *JobSpec `json:"onSuccess,omitempty"`
+	OnFailure  *JobSpec `json:"onFailure,omitempty"`
+}
+
+// Job is the representation of client.Job in the REST API.
+type Job struct {
+	ID              string     `json:"id"`
+	Queue           string     `json:"queue"`
+	Batch           string     `json:"batch,omitempty"`
+	Payload         string     `json:"payload,omitempty"`
+	Phase           string     `json:"phase"`
+	Attempts        int        `json:"attempts,omitempty"`
+	EnqueueTime     *time.Time `json:"enqueueTime,omitempty"`
+	CompletionTime  *time.Time `json:"completionTime,omitempty"`
+	Progress        int        `json:"progress,omitempty"`
+	ProgressMessage string     `json:"progressMessage,omitempty"`
+	Result          string     `json:"result,omitempty"`
+	// Lease is the lease under which a dequeued job is held, see
+	// JobsHandler.Heartbeat.
+	Lease string `json:"lease,omitempty"`
+}
+
+// AckRequest is the body of an acknowledgement.
+type AckRequest struct {
+	// Result is the output of the job, see client.GetResult.
+	Result string `json:"result,omitempty"`
+	// Lease must be the lease of the job returned by the dequeue, so that a
+	// consumer whose lease has expired is turned down.
+	Lease string `json:"lease"`
+}
+
+// FailRequest is the body of a failure.
+type FailRequest struct {
+	// Message describes the failure. It is logged.
+	Message string `json:"message,omitempty"`
+	// Retry requeues the job if it has retries left, see
+	// client.JobSpec.MaxRetries, instead of marking it as failed.
+	Retry bool `json:"retry,omitempty"`
+	// Lease is the lease of the job, see AckRequest.
+	Lease string `json:"lease"`
+}
+
+// HeartbeatRequest is the body of a heartbeat.
+type HeartbeatRequest struct {
+	// Lease is the lease of the job, see AckRequest.
+	Lease string `json:"lease"`
+}
+
+var (
+	// ErrLeaseExpired is returned when the lease of a request is not the
+	// lease of the job, which has been requeued and dequeued again since.
+	ErrLeaseExpired = errors.New("lease expired")
+	// ErrLeaseRequired is returned when a request on a running job has no
+	// lease.
+	ErrLeaseRequired = errors.New("lease required")
+)
+
+// JobsHandler serves the jobs of the redis queues shared with the workers.
+type JobsHandler struct {
+	client     *client.Client
+	waiters    *WaitLimiter
+	watchers   *WatchLimiter
+	leaseTTL   time.Duration
+	authorizer auth.Authorizer
+}
+
+// NewJobsHandler creates a new JobsHandler object. The dequeued jobs are
+// held for leaseTTL without a heartbeat, DefaultLeaseTTL if zero. The
+// follow-up jobs of an enqueued job are authorized with authorizer, unless
+// it is nil, as the queue of the path is by the handler chain.
+func NewJobsHandler(cli *client.Client, waiters *WaitLimiter, watchers *WatchLimiter, leaseTTL time.Duration, authorizer auth.Authorizer) *JobsHandler {
+	if leaseTTL <= 0 {
+		leaseTTL = DefaultLeaseTTL
+	}
+	return &JobsHandler{client: cli, waiters: waiters, watchers: watchers, leaseTTL: leaseTTL, authorizer: authorizer}
+}
+
+// Enqueue enqueues the job of the JobSpec in the body into the queue of the
+// path, and replies 201 with the job.
+func (h *JobsHandler) Enqueue(request *restful.Request, response *restful.Response) {
+	var spec JobSpec
+	if err := json.NewDecoder(request.Request.Body).Decode(&spec); err != nil {
+		http.Error(response, "apiserver: error reading body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queue := request.PathParameter("queue")
+	spec.Queue = queue
+	clientSpec, err := spec.clientSpec()
+	if err != nil {
+		http.Error(response, "apiserver: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if queue, ok := h.authorizeFollowUps(request, &clientSpec); !ok {
+		http.Error(response, "apiserver: forbidden to enqueue into "+queue, http.StatusForbidden)
+		return
+	}
+
+	job, err := h.client.EnqueueContext(request.Request.Context(), clientSpec)
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+	zap.L().Debug("enqueued job", zap.String("job", job.ID), zap.String("queue", queue))
+	utilruntime.HandleError(utilhttp.JSON(response, newJob(job), http.StatusCreated))
+}
+
+// authorizeFollowUps authorizes the user of request to enqueue the
+// follow-ups of spec, and those of the follow-ups in turn, into their
+// queues. It returns the first queue which is not allowed.
+func (h *JobsHandler) authorizeFollowUps(request *restful.Request, spec *client.JobSpec) (string, bool) {
+	if h.authorizer == nil {
+		return "", true
+	}
+
+	for _, followUp := range []*client.JobSpec{spec.OnSuccess, spec.OnFailure} {
+		if followUp == nil {
+			continue
+		}
+		attrs := &auth.Attributes{
+			User:  auth.UserFrom(request.Request.Context()),
+			Verb:  auth.VerbEnqueue,
+			Queue: followUp.QueueName,
+		}
+		if !h.authorizer.Authorize(attrs) {
+			return followUp.QueueName, false
+		}
+		if queue, ok := h.authorizeFollowUps(request, followUp); !ok {
+			return queue, false
+		}
+	}
+	return "", true
+}
+
+// Dequeue moves the oldest pending job of the queue of the path to running,
+// and replies with the job, or with 204 if the queue is empty. With the wait
+// query parameter, it blocks until a job is pending or the timeout. The job
+// is held under a lease, which the consumer renews with heartbeats until it
+// acknowledges or fails the job.
+func (h *JobsHandler) Dequeue(request *restful.Request, response *restful.Response) {
+	ctx, wait, done, ok := h.waiters.start(request, response)
+	if !ok {
+		return
+	}
+	defer done()
+
+	queue := request.PathParameter("queue")
+	job, err := h.dequeue(ctx, queue, wait)
+	if err == client.ErrNoAvailableJob && ctx.Err() != nil {
+		writeAbandoned(response)
+		return
+	}
+	if err == client.ErrNoAvailableJob {
+		response.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+
+	if err := request.Request.Context().Err(); err != nil {
+		// the consumer went away while waiting, give the job back
+		zap.L().Info("dequeue request cancelled, retrying job", zap.String("job", job.ID), zap.String("queue", queue))
+		utilruntime.HandleError(h.client.Retry(job))
+		writeAbandoned(response)
+		return
+	}
+
+	if err := renewLease(h.client, job, request.Request.RemoteAddr, h.leaseTTL); err != nil {
+		// the job would only be requeued once the reapers find it owned by
+		// no lease, see client.ReapDeadWorkers
+		utilruntime.HandleError(h.client.Retry(job))
+		writeError(response, err)
+		return
+	}
+
+	res := newJob(job)
+	res.Lease = leaseID(job)
+	utilruntime.HandleError(utilhttp.JSON(response, res, http.StatusOK))
+}
+
+// dequeue dequeues a job of queue, waiting for one until wait has elapsed,
+// or ctx is done, e.g. when the consumer goes away or the apiserver shuts
+// down, see WaitLimiter. Redis blocks for one second at least, and the wait
+// is split into blocking pops of one second so that ctx is checked in
+// between. Without wait, it does not block.
+func (h *JobsHandler) dequeue(ctx context.Context, queue string, wait time.Duration) (*client.Job, error) {
+	if wait <= 0 {
+		return h.client.TryDequeue(queue)
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		job, err := h.client.DequeueTimeout(queue, time.Second)
+		if err != client.ErrNoAvailableJob || ctx.Err() != nil || !time.Now().Before(deadline) {
+			return job, err
+		}
+	}
+}
+
+// Get replies with the job of the path.
+func (h *JobsHandler) Get(request *restful.Request, response *restful.Response) {
+	job, err := h.client.GetJob(request.PathParameter("queue"), request.PathParameter("id"))
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+	utilruntime.HandleError(utilhttp.JSON(response, newJob(job), http.StatusOK))
+}
+
+// Ack marks the running job of the path as done, with the result of the
+// AckRequest in the body, and releases its lease. It replies 409 if the job
+// is not running under the lease any more, e.g. when it was acknowledged
+// already.
+func (h *JobsHandler) Ack(request *restful.Request, response *restful.Response) {
+	var ack AckRequest
+	if !readBody(request, response, &ack) {
+		return
+	}
+
+	job, err := h.leasedJob(request, ack.Lease)
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+
+	job.Status.Result = ack.Result
+	if err := h.client.MarkAsDone(job); err != nil {
+		writeError(response, err)
+		return
+	}
+	utilruntime.HandleError(h.client.Unregister(leaseID(job)))
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// Fail marks the running job of the path as failed, or requeues it if the
+// FailRequest in the body asks for a retry and the job has retries left, and
+// releases its lease. It replies 409 as Ack does.
+func (h *JobsHandler) Fail(request *restful.Request, response *restful.Response) {
+	var fail FailRequest
+	if !readBody(request, response, &fail) {
+		return
+	}
+
+	job, err := h.leasedJob(request, fail.Lease)
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+
+	logger := zap.L().With(
+		zap.String("job", job.ID),
+		zap.String("queue", job.Spec.QueueName),
+		zap.Int("attempt", job.Status.Attempts),
+		zap.String("message", fail.Message),
+	)
+	if fail.Retry && job.Status.Attempts <= job.Spec.MaxRetries {
+		logger.Info("retrying job", zap.Int("maxAttempts", job.Spec.MaxRetries+1))
+		err = h.client.Retry(job)
+	} else {
+		logger.Info("job failed")
+		err = h.client.MarkAsFailed(job)
+	}
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+	utilruntime.HandleError(h.client.Unregister(leaseID(job)))
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// Heartbeat renews the lease of the running job of the path for the lease
+// TTL. A job whose lease expires is requeued.
+func (h *JobsHandler) Heartbeat(request *restful.Request, response *restful.Response) {
+	var heartbeat HeartbeatRequest
+	if !readBody(request, response, &heartbeat) {
+		return
+	}
+
+	job, err := h.leasedJob(request, heartbeat.Lease)
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+
+	if err := renewLease(h.client, job, request.Request.RemoteAddr, h.leaseTTL); err != nil {
+		writeError(response, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// leasedJob returns the job of the path, or client.ErrJobNotRunning if it
+// is not running, or ErrLeaseExpired if lease is not the lease of the job.
+// The job is finished by the client only if it is still running the same
+// attempt, see client.MarkAsDone.
+func (h *JobsHandler) leasedJob(request *restful.Request, lease string) (*client.Job, error) {
+	if lease == "" {
+		return nil, ErrLeaseRequired
+	}
+
+	job, err := h.client.GetJob(request.PathParameter("queue"), request.PathParameter("id"))
+	if err != nil {
+		return nil, err
+	}
+	if job.Status.Phase != client.JobRunning {
+		return nil, client.ErrJobNotRunning
+	}
+	if lease != leaseID(job) {
+		return nil, ErrLeaseExpired
+	}
+	return job, nil
+}
+
+// readBody decodes the optional JSON body of request into v. If it is
+// invalid, it replies 400 and reports false.
+func readBody(request *restful.Request, response *restful.Response, v interface{}) bool {
+	if err := json.NewDecoder(request.Request.Body).Decode(v); err != nil && err != io.EOF {
+		http.Error(response, "apiserver: error reading body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// clientSpec converts s to a client.JobSpec.
+func (s *JobSpec) clientSpec() (client.JobSpec, error) {
+	spec := client.JobSpec{
+		Payload:    s.Payload,
+		QueueName:  s.Queue,
+		Batch:      s.Batch,
+		MaxRetries: s.MaxRetries,
+		DependsOn:  s.DependsOn,
+		LockKey:    s.LockKey,
+	}
+
+	var err error
+	if s.Timeout != "" {
+		if spec.Timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return spec, err
+		}
+	}
+	if s.ResultTTL != "" {
+		if spec.ResultTTL, err = time.ParseDuration(s.ResultTTL); err != nil {
+			return spec, err
+		}
+	}
+
+	for _, f := range []struct {
+		in  *JobSpec
+		out **client.JobSpec
+	}{
+		{s.OnSuccess, &spec.OnSuccess},
+		{s.OnFailure, &spec.OnFailure},
+	} {
+		if f.in == nil {
+			continue
+		}
+		followUp, err := f.in.clientSpec()
+		if err != nil {
+			return spec, err
+		}
+		*f.out = &followUp
+	}
+	return spec, nil
+}
+
+func newJob(job *client.Job) *Job {
+	return &Job{
+		ID:              job.ID,
+		Queue:           job.Spec.QueueName,
+		Batch:           job.Spec.Batch,
+		Payload:         job.Spec.Payload,
+		Phase:           string(job.Status.Phase),
+		Attempts:        job.Status.Attempts,
+		EnqueueTime:     job.Status.EnqueueTime,
+		CompletionTime:  job.Status.CompletionTime,
+		Progress:        job.Status.Progress,
+		ProgressMessage: job.Status.ProgressMessage,
+		Result:          job.Status.Result,
+	}
+}
+
+// writeAbandoned replies 503 to a dequeue request which stopped waiting for
+// a job before its wait elapsed, because the apiserver is shutting down or
+// the consumer went away, so that the consumer tries again.
+func writeAbandoned(response *restful.Response) {
+	response.Header().Set("Retry-After", "1")
+	http.Error(response, "apiserver: dequeue abandoned", http.StatusServiceUnavailable)
+}
+
+// writeError replies with the status code matching err.
+func writeError(response *restful.Response, err error) {
+	code := http.StatusInternalServerError
+	switch err {
+	case client.ErrJobNotFound:
+		code = http.StatusNotFound
+	case client.ErrJobNotRunning, client.ErrJobNotPending, ErrLeaseExpired:
+		code = http.StatusConflict
+	case client.ErrNoAvailableKey, client.ErrUnknownDependency, client.ErrInvalidPhase, ErrLeaseRequired:
+		code = http.StatusBadRequest
+	default:
+		zap.L().Error("request failed", zap.Error(err))
+	}
+	http.Error(response, "apiserver: "+err.Error(), code)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/util/version"
+)
+
+// DefaultLeaseTTL is how long a job dequeued over HTTP is held by its
+// consumer without a heartbeat.
+const DefaultLeaseTTL = 30 * time.Second
+
+// A job dequeued over HTTP is held under a lease, registered as a worker
+// holding the job alone, see client.Heartbeat. The consumer renews the lease
+// with heartbeats until it acknowledges or fails the job. A lease which
+// expires is reaped like a dead worker, see client.ReapDeadWorkers, and its
+// job is requeued. A job dequeued but never leased, because the lease could
+// not be registered or the apiserver died in between, is requeued as well,
+// as it is owned by no worker.
+
+// leaseID returns the ID of the lease of the current attempt of job. The
+// lease of an earlier attempt, which has expired, does not match.
+func leaseID(job *client.Job) string {
+	return fmt.Sprintf("http-%s-%d", job.ID, job.Status.Attempts)
+}
+
+// renewLease registers, or refreshes, the lease of job held by the consumer
+// at remoteAddr, for ttl.
+func renewLease(cli *client.Client, job *client.Job, remoteAddr string, ttl time.Duration) error {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	start := time.Now()
+	if job.Status.StartTime != nil {
+		start = *job.Status.StartTime
+	}
+
+	return cli.Heartbeat(&client.WorkerInfo{
+		ID:        leaseID(job),
+		Hostname:  host,
+		Queues:    []string{job.Spec.QueueName},
+		Version:   version.Version,
+		StartTime: start,
+		Jobs:      map[string]string{job.ID: job.Spec.QueueName},
+	}, ttl)
+}
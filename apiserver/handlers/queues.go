@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"denggotech.cn/heque/heque/client"
+	utilhttp "denggotech.cn/heque/heque/util/http"
+	utilruntime "denggotech.cn/heque/heque/util/runtime"
+)
+
+const (
+	// DefaultPageLimit is the number of jobs of a page when the request does
+	// not say.
+	DefaultPageLimit = 50
+	// MaxPageLimit is the largest number of jobs of a page.
+	MaxPageLimit = 500
+)
+
+// Batch is the representation of the counters of a batch in the REST API.
+type Batch struct {
+	Name string `json:"name"`
+	client.BatchStatus
+	// Progress is the share of the jobs of the batch which are finished,
+	// between 0 and 1.
+	Progress float64 `json:"progress"`
+}
+
+// QueueList is the list of the queues.
+type QueueList struct {
+	Queues []*client.QueueStatus `json:"queues"`
+}
+
+// JobList is a page of the jobs of a queue in a given phase.
+type JobList struct {
+	Jobs []*Job `json:"jobs"`
+	// Total is the number of jobs in the phase, of all the pages.
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// GetBatch replies with the counters and the progress of the batch of the
+// path, or with 404 if it has no job.
+func (h *JobsHandler) GetBatch(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("batch")
+	status, err := h.client.BatchStatus(name)
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+	if status.Pending+status.Running+status.Done+status.Failed+status.Cancelled == 0 {
+		http.Error(response, "apiserver: batch not found", http.StatusNotFound)
+		return
+	}
+
+	batch := &Batch{Name: name, BatchStatus: *status, Progress: status.Progress()}
+	utilruntime.HandleError(utilhttp.JSON(response, batch, http.StatusOK))
+}
+
+// ListQueues replies with the queues, their jobs by state and their
+// consumers.
+func (h *JobsHandler) ListQueues(request *restful.Request, response *restful.Response) {
+	queues, err := h.client.Queues()
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+	utilruntime.HandleError(utilhttp.JSON(response, &QueueList{Queues: queues}, http.StatusOK))
+}
+
+// ListJobs replies with a page of the jobs of the queue of the path in the
+// phase given by the state query parameter, pending by default. The page is
+// given by the offset and limit query parameters.
+func (h *JobsHandler) ListJobs(request *restful.Request, response *restful.Response) {
+	phase := client.JobPending
+	if state := request.QueryParameter("state"); state != "" {
+		phase = client.JobPhase(state)
+	}
+
+	offset, ok := intParameter(request, response, "offset", 0, 0)
+	if !ok {
+		return
+	}
+	limit, ok := intParameter(request, response, "limit", DefaultPageLimit, 1)
+	if !ok {
+		return
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	jobs, total, err := h.client.QueueJobs(request.PathParameter("queue"), phase, offset, limit)
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+
+	list := &JobList{Jobs: make([]*Job, 0, len(jobs)), Total: total, Offset: offset, Limit: limit}
+	for _, job := range jobs {
+		list.Jobs = append(list.Jobs, newJob(job))
+	}
+	utilruntime.HandleError(utilhttp.JSON(response, list, http.StatusOK))
+}
+
+// intParameter reads the integer query parameter name of request, def if it
+// is not set. If it is invalid, or less than min, it replies 400 and reports
+// false.
+func intParameter(request *restful.Request, response *restful.Response, name string, def int, min int) (int, bool) {
+	param := request.QueryParameter(name)
+	if param == "" {
+		return def, true
+	}
+
+	n, err := strconv.Atoi(param)
+	if err != nil || n < min {
+		http.Error(response, "apiserver: invalid "+name+" "+param, http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+)
+
+const (
+	// DefaultMaxWaiters is the default number of dequeue requests waiting
+	// for a job at the same time. Every waiter of the redis backend holds a
+	// connection of the client pool.
+	DefaultMaxWaiters = 10
+	// DefaultMaxWait is the default longest wait of a dequeue request.
+	DefaultMaxWait = 30 * time.Second
+)
+
+// WaitLimiter limits the dequeue requests waiting for a job, given by the
+// wait query parameter, e.g. GET /registry/jobs/{queue}?wait=20s.
+type WaitLimiter struct {
+	maxWait time.Duration
+	waiters chan struct{}
+	stopper
+}
+
+// NewWaitLimiter creates a new WaitLimiter object allowing maxWaiters
+// requests to wait at most maxWait at the same time. Zero values are
+// replaced by DefaultMaxWaiters and DefaultMaxWait.
+func NewWaitLimiter(maxWaiters int, maxWait time.Duration) *WaitLimiter {
+	if maxWaiters <= 0 {
+		maxWaiters = DefaultMaxWaiters
+	}
+	if maxWait <= 0 {
+		maxWait = DefaultMaxWait
+	}
+	return &WaitLimiter{
+		maxWait: maxWait,
+		waiters: make(chan struct{}, maxWaiters),
+		stopper: newStopper(),
+	}
+}
+
+// start reads the wait query parameter of request, capped at the longest
+// wait, and takes a slot for the waiter if it is positive. It returns the
+// context of the wait, which is done with the request or once the limiter
+// is stopped. The slot is given back by calling done. If the parameter is
+// invalid, or all the slots are taken, it replies with an error and reports
+// false.
+func (l *WaitLimiter) start(request *restful.Request, response *restful.Response) (context.Context, time.Duration, func(), bool) {
+	ctx := request.Request.Context()
+	param := request.QueryParameter("wait")
+	if param == "" {
+		return ctx, 0, func() {}, true
+	}
+
+	wait, err := time.ParseDuration(param)
+	if err != nil || wait < 0 {
+		http.Error(response, "apiserver: invalid wait "+param, http.StatusBadRequest)
+		return nil, 0, nil, false
+	}
+	if wait == 0 {
+		return ctx, 0, func() {}, true
+	}
+	if wait > l.maxWait {
+		wait = l.maxWait
+	}
+
+	select {
+	case l.waiters <- struct{}{}:
+		ctx, cancel := l.context(ctx)
+		return ctx, wait, func() { cancel(); <-l.waiters }, true
+	default:
+		response.Header().Set("Retry-After", "1")
+		http.Error(response, "apiserver: too many waiting requests", http.StatusTooManyRequests)
+		return nil, 0, nil, false
+	}
+}
+
+// stopper ends the long-running requests, which wait for a job or stream
+// events, on shutdown. The other requests in progress are left to finish.
+type stopper struct {
+	stopCh   chan struct{}
+	stopOnce *sync.Once
+}
+
+func newStopper() stopper {
+	return stopper{stopCh: make(chan struct{}), stopOnce: new(sync.Once)}
+}
+
+// Stop ends the requests in progress, and the following ones at once.
+func (s stopper) Stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
+// context returns a context derived from parent, which is done once Stop
+// is called.
+func (s stopper) context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-s.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/client"
+)
+
+// keepAliveInterval is how often an idle stream sends a comment, so that
+// proxies do not close it.
+const keepAliveInterval = 15 * time.Second
+
+// DefaultMaxWatchers is the default number of batch streams open at the same
+// time. Every stream holds a redis Pub/Sub connection.
+const DefaultMaxWatchers = 100
+
+// WatchLimiter limits the streams of WatchBatch open at the same time.
+type WatchLimiter struct {
+	watchers chan struct{}
+	stopper
+}
+
+// NewWatchLimiter creates a new WatchLimiter object allowing maxWatchers
+// streams at the same time, DefaultMaxWatchers if zero.
+func NewWatchLimiter(maxWatchers int) *WatchLimiter {
+	if maxWatchers <= 0 {
+		maxWatchers = DefaultMaxWatchers
+	}
+	return &WatchLimiter{
+		watchers: make(chan struct{}, maxWatchers),
+		stopper:  newStopper(),
+	}
+}
+
+// start takes a slot for a stream of request, which is given back by calling
+// done. It returns the context of the stream, which is done with the request
+// or once the limiter is stopped. If all the slots are taken, it replies
+// with an error and reports false.
+func (l *WatchLimiter) start(request *restful.Request, response *restful.Response) (context.Context, func(), bool) {
+	select {
+	case l.watchers <- struct{}{}:
+		ctx, cancel := l.context(request.Request.Context())
+		return ctx, func() { cancel(); <-l.watchers }, true
+	default:
+		response.Header().Set("Retry-After", "1")
+		http.Error(response, "apiserver: too many watching requests", http.StatusTooManyRequests)
+		return nil, nil, false
+	}
+}
+
+// WatchBatch streams the counters and the progress of the batch of the path
+// as Server-Sent Events. A "status" event is sent at once, then every time
+// jobs of the batch change state, and a "completed" event when the last job
+// finishes, after which the stream ends. The events are driven by the job
+// lifecycle events published in redis, see client.Subscribe; bursts of them
+// are coalesced into a single update. The streams are limited, see
+// WatchLimiter.
+func (h *JobsHandler) WatchBatch(request *restful.Request, response *restful.Response) {
+	ctx, done, ok := h.watchers.start(request, response)
+	if !ok {
+		return
+	}
+	defer done()
+
+	name := request.PathParameter("batch")
+
+	// subscribe before reading the counters, so that no change is missed
+	sub, err := h.client.Subscribe(client.EventFilter{Batches: []string{name}})
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+	defer sub.Close()
+
+	status, err := h.client.BatchStatus(name)
+	if err != nil {
+		writeError(response, err)
+		return
+	}
+	if status.Pending+status.Running+status.Done+status.Failed+status.Cancelled == 0 {
+		http.Error(response, "apiserver: batch not found", http.StatusNotFound)
+		return
+	}
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("Connection", "keep-alive")
+	// nginx buffers the responses otherwise
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+
+	logger := zap.L().With(zap.String("batch", name))
+	if status.Pending+status.Running == 0 {
+		writeBatchEvent(response, logger, "completed", name, status)
+		return
+	}
+	if !writeBatchEvent(response, logger, "status", name, status) {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	events := sub.Events()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(response, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			response.Flush()
+			continue
+		case e, ok := <-events:
+			// coalesce the events already received
+			for ok && e.Type != client.EventBatchCompleted && len(events) > 0 {
+				e, ok = <-events
+			}
+			if !ok {
+				return
+			}
+			if e.Type == client.EventBatchCompleted {
+				writeBatchEvent(response, logger, "completed", name, e.BatchStatus)
+				return
+			}
+		}
+
+		status, err := h.client.BatchStatus(name)
+		if err != nil {
+			logger.Error("failed to read batch status", zap.Error(err))
+			return
+		}
+		if !writeBatchEvent(response, logger, "status", name, status) {
+			return
+		}
+	}
+}
+
+// writeBatchEvent sends a Server-Sent Event of type event with the counters
+// of the batch. It reports false if the stream is broken.
+func writeBatchEvent(response *restful.Response, logger *zap.Logger, event string, name string, status *client.BatchStatus) bool {
+	data, err := json.Marshal(&Batch{Name: name, BatchStatus: *status, Progress: status.Progress()})
+	if err != nil {
+		logger.Error("failed to encode batch event", zap.Error(err))
+		return false
+	}
+	if _, err := fmt.Fprintf(response, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return false
+	}
+	response.Flush()
+	return true
+}
@@ -1,41 +1,188 @@
 package apiserver
 
 import (
-	"denggotech.cn/heque/heque/apiserver/handlers"
+	"context"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/emicklei/go-restful/v3"
-	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/apiserver/auth"
+	"denggotech.cn/heque/heque/apiserver/handlers"
+	"denggotech.cn/heque/heque/client"
+	utilruntime "denggotech.cn/heque/heque/util/runtime"
 )
 
 // APIServer is the main servlet implementation.
 type APIServer struct {
-	cfg *Config
+	cfg     *Config
+	handler http.Handler
+	// waiters and watchers end the long-running requests on shutdown
+	waiters  *handlers.WaitLimiter
+	watchers *handlers.WatchLimiter
 }
 
 // NewAPIServer creates and initializes a new APIServer object.
 func NewAPIServer(cfg *Config) *APIServer {
+	waiters := handlers.NewWaitLimiter(cfg.MaxDequeueWaiters, cfg.MaxDequeueWait)
+	watchers := handlers.NewWatchLimiter(cfg.MaxBatchWatchers)
 	s := &APIServer{
-		cfg: cfg,
+		cfg:      cfg,
+		waiters:  waiters,
+		watchers: watchers,
 	}
 
 	ws := new(restful.WebService)
-	ws.Route(ws.POST("/registry/jobs/{queue}").To(func(req *restful.Request, res *restful.Response) {
-		handlers.EnqueueJobHandler(cfg.Storage, cfg.Prefix, req, res)
-	}).
-		Doc("enqueue a job into specified queue"))
-	ws.Route(ws.GET("/registry/jobs/{queue}").To(func(req *restful.Request, res *restful.Response) {
-		handlers.DequeueJobHandler(cfg.Storage, cfg.Prefix, req, res)
-	}).
-		Doc("dequeue a job into specified queue"))
+	switch cfg.StorageBackend {
+	case StorageBackendETCD:
+		ws.Route(ws.POST("/registry/jobs/{queue}").To(func(req *restful.Request, res *restful.Response) {
+			handlers.EnqueueJobHandler(cfg.Storage, cfg.Prefix, req, res)
+		}).
+			Doc("enqueue a job into specified queue"))
+		ws.Route(ws.GET("/registry/jobs/{queue}").To(func(req *restful.Request, res *restful.Response) {
+			handlers.DequeueJobHandler(cfg.Storage, cfg.Prefix, waiters, req, res)
+		}).
+			Doc("dequeue a job from specified queue").
+			Param(ws.QueryParameter("wait", "how long to wait for a job if the queue is empty, e.g. 20s")))
+	default:
+		// the follow-ups are authorized as the requests are, see
+		// buildHandlerChain
+		var authorizer auth.Authorizer
+		if cfg.Authenticator != nil {
+			authorizer = cfg.Authorizer
+		}
+		jobs := handlers.NewJobsHandler(cfg.Client, waiters, watchers, cfg.LeaseTTL, authorizer)
+		ws.Route(ws.POST("/registry/jobs/{queue}").To(jobs.Enqueue).
+			Doc("enqueue a job into specified queue"))
+		ws.Route(ws.GET("/registry/jobs/{queue}").To(jobs.Dequeue).
+			Doc("dequeue a job from specified queue").
+			Param(ws.QueryParameter("wait", "how long to wait for a job if the queue is empty, e.g. 20s")))
+		ws.Route(ws.GET("/registry/jobs/{queue}/{id}").To(jobs.Get).
+			Doc("read the specified job"))
+		ws.Route(ws.POST("/registry/jobs/{queue}/{id}/ack").To(jobs.Ack).
+			Doc("mark the specified running job as done"))
+		ws.Route(ws.POST("/registry/jobs/{queue}/{id}/fail").To(jobs.Fail).
+			Doc("mark the specified running job as failed, or retry it"))
+		ws.Route(ws.POST("/registry/jobs/{queue}/{id}/heartbeat").To(jobs.Heartbeat).
+			Doc("renew the lease of the specified running job"))
+		ws.Route(ws.GET("/registry/batches/{batch}").To(jobs.GetBatch).
+			Doc("read the counters and the progress of the specified batch"))
+		ws.Route(ws.GET("/registry/batches/{batch}/watch").To(jobs.WatchBatch).
+			Doc("stream the counters and the progress of the specified batch as Server-Sent Events").
+			Produces("text/event-stream"))
+		ws.Route(ws.GET("/registry/queues").To(jobs.ListQueues).
+			Doc("list the queues with their jobs by state and their consumers"))
+		ws.Route(ws.GET("/registry/queues/{queue}/jobs").To(jobs.ListJobs).
+			Doc("list the jobs of specified queue").
+			Param(ws.QueryParameter("state", "pending (default), running or failed")).
+			Param(ws.QueryParameter("offset", "the number of jobs to skip")).
+			Param(ws.QueryParameter("limit", "the number of jobs of the page, 50 by default")))
+	}
+
+	container := restful.NewContainer()
+	container.Add(ws)
 
-	restful.DefaultContainer.Add(ws)
+	// the queue and batch gauges, read from redis on every scrape, are
+	// registered with a registry of the server, and the job counters with
+	// the default one
+	utilruntime.HandleError(client.RegisterMetrics(prometheus.DefaultRegisterer))
+	reg := prometheus.NewRegistry()
+	if cfg.StorageBackend != StorageBackendETCD {
+		reg.MustRegister(client.NewCollector(cfg.Client))
+	}
+	container.Handle("/metrics", promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, reg}, promhttp.HandlerOpts{}))
 
+	s.handler = buildHandlerChain(container, cfg)
 	return s
 }
 
-// ListenAndServe runs the servlet HTTP server.
-func (s *APIServer) ListenAndServe(addr string) error {
-	glog.Infof("Serving HTTP at http://%s", addr)
-	return http.ListenAndServe(addr, nil)
+// ReapLeases requeues the jobs whose lease has expired, see
+// handlers.JobsHandler, until stopCh is closed. The workers reap them as
+// well, but there may be none running.
+func (s *APIServer) ReapLeases(stopCh <-chan struct{}) {
+	if s.cfg.StorageBackend == StorageBackendETCD {
+		return
+	}
+
+	ttl := s.cfg.LeaseTTL
+	if ttl <= 0 {
+		ttl = handlers.DefaultLeaseTTL
+	}
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		n, err := s.cfg.Client.ReapDeadWorkers()
+		if err != nil {
+			zap.L().Error("failed to reap expired leases", zap.Error(err))
+		} else if n > 0 {
+			zap.L().Info("requeued jobs of expired leases", zap.Int("jobs", n))
+		}
+	}
+}
+
+// Run serves HTTP on addr, or HTTPS if a TLS certificate is configured,
+// until stopCh is closed, and then shuts the server down: it stops accepting
+// connections, ends the streams and the dequeue requests waiting for a job,
+// and waits for the other requests in progress until the shutdown timeout,
+// after which their connections are closed.
+func (s *APIServer) Run(addr string, stopCh <-chan struct{}) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+
+	scheme := "http"
+	if s.cfg.TLSCertFile != "" {
+		certs, err := newCertReloader(s.cfg.TLSCertFile, s.cfg.TLSPrivateKeyFile, s.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = certs.TLSConfig()
+		go certs.Run(stopCh)
+		scheme = "https"
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			// the certificate is served by the TLS config
+			errCh <- srv.ListenAndServeTLS("", "")
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+	zap.L().Info("serving "+strings.ToUpper(scheme), zap.String("address", scheme+"://"+addr))
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-stopCh:
+	}
+
+	zap.L().Info("shutting down HTTP server", zap.Duration("timeout", s.cfg.ShutdownTimeout))
+	s.waiters.Stop()
+	s.watchers.Stop()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		zap.L().Warn("requests still in progress, closing their connections", zap.Error(err))
+		return srv.Close()
+	}
+	return nil
+}
+
+// ServeHTTP serves a request through the handler chain.
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.handler.ServeHTTP(w, req)
 }
@@ -0,0 +1,138 @@
+package apiserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes.
+const certReloadInterval = 10 * time.Second
+
+// certReloader serves the certificate and the client CAs of files, which it
+// reloads when they change, so that they are renewed without a restart.
+type certReloader struct {
+	certFile, keyFile, clientCAFile string
+
+	mu sync.RWMutex
+	// contents are the contents of the files the config was loaded from.
+	contents [][]byte
+	config   *tls.Config
+}
+
+// newCertReloader loads the certificate of certFile and keyFile, and the
+// client CAs of clientCAFile unless it is empty.
+func newCertReloader(certFile, keyFile, clientCAFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the TLS config of the server, which reads the current
+// certificate and client CAs on every handshake. With client CAs, the
+// client certificates are verified if there are any, see
+// auth.X509Authenticator. The current certificate is also served by
+// GetCertificate, without which http.Server.ListenAndServeTLS requires
+// certificate files.
+func (r *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &r.config.Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.config, nil
+		},
+	}
+}
+
+// reload reads the files and, if they have changed, loads them. It reports
+// whether they were loaded.
+func (r *certReloader) reload() (bool, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	contents := make([][]byte, len(files))
+	for i, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+		contents[i] = data
+	}
+
+	r.mu.RLock()
+	changed := r.config == nil
+	for i := range contents {
+		if !changed && !bytes.Equal(contents[i], r.contents[i]) {
+			changed = true
+		}
+	}
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return false, fmt.Errorf("%s, %s: %v", r.certFile, r.keyFile, err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.clientCAFile != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(contents[2]) {
+			return false, fmt.Errorf("%s: no PEM certificate", r.clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	r.mu.Lock()
+	r.contents = contents
+	r.config = config
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Run reloads the files when they change, until stopCh is closed. The
+// certificate in use is kept if the files are invalid, e.g. while they are
+// being written.
+func (r *certReloader) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := r.reload()
+		if err != nil {
+			zap.L().Error("failed to reload TLS certificate", zap.Error(err))
+		} else if reloaded {
+			zap.L().Info("reloaded TLS certificate",
+				zap.String("certFile", r.certFile), zap.String("clientCAFile", r.clientCAFile))
+		}
+	}
+}
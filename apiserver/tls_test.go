@@ -0,0 +1,195 @@
+package apiserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signed by a CA or self-signed.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCert creates a certificate named cn, signed by ca unless it is nil.
+// A CA certificate is self-signed.
+func newTestCert(t *testing.T, cn string, ca *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	parent, signer := tmpl, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// writeServerCert writes the certificate and the key of the server.
+func writeServerCert(t *testing.T, dir string, c *testCert) {
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.crt"), c.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.key"), c.keyPEM(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "heque-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "heque-ca", nil, true)
+	writeServerCert(t, dir, newTestCert(t, "heque-apiserver", ca, false))
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.crt"), ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newCertReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := r.TLSConfig()
+	if cert, err := config.GetCertificate(nil); err != nil || cert == nil {
+		t.Fatalf("expected the certificate from GetCertificate, got %v %v", cert, err)
+	}
+
+	// served as Run does, with the certificate of the TLS config
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		TLSConfig: config,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if len(req.TLS.VerifiedChains) > 0 {
+				fmt.Fprint(w, req.TLS.VerifiedChains[0][0].Subject.CommonName)
+			}
+		}),
+	}
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCert *testCert) (string, string, error) {
+		tlsConfig := &tls.Config{RootCAs: roots}
+		if clientCert != nil {
+			// sent even if its CA is not one the server asks for
+			cert := clientCert.tlsCertificate(t)
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &cert, nil
+			}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+		res, err := client.Get("https://" + ln.Addr().String() + "/")
+		if err != nil {
+			return "", "", err
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		return res.TLS.PeerCertificates[0].Subject.CommonName, string(body), err
+	}
+
+	server, user, err := get(nil)
+	if err != nil || server != "heque-apiserver" || user != "" {
+		t.Fatalf("expected heque-apiserver without a client certificate, got %q %q %v", server, user, err)
+	}
+	_, user, err = get(newTestCert(t, "heque-worker", ca, false))
+	if err != nil || user != "heque-worker" {
+		t.Errorf("expected the client certificate of heque-worker to be verified, got %q %v", user, err)
+	}
+	other := newTestCert(t, "other-ca", nil, true)
+	if _, _, err := get(newTestCert(t, "intruder", other, false)); err == nil {
+		t.Errorf("expected a client certificate of another CA to be turned down")
+	}
+
+	if reloaded, err := r.reload(); err != nil || reloaded {
+		t.Errorf("expected no reload of unchanged files, got %v %v", reloaded, err)
+	}
+	writeServerCert(t, dir, newTestCert(t, "heque-apiserver-renewed", ca, false))
+	if reloaded, err := r.reload(); err != nil || !reloaded {
+		t.Fatalf("expected a reload of the renewed certificate, got %v %v", reloaded, err)
+	}
+	server, _, err = get(nil)
+	if err != nil || server != "heque-apiserver-renewed" {
+		t.Errorf("expected the renewed certificate to be served, got %q %v", server, err)
+	}
+
+	// a certificate being written is not loaded, and the current one is kept
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.crt"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reload(); err == nil {
+		t.Errorf("expected an invalid certificate to be turned down")
+	}
+	server, _, err = get(nil)
+	if err != nil || server != "heque-apiserver-renewed" {
+		t.Errorf("expected the current certificate to be kept, got %q %v", server, err)
+	}
+}
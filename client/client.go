@@ -1,31 +1,75 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/util/tracing"
 )
 
 var (
 	ErrNoAvailableEndpoints = errors.New("heque_redis_client: no available endpoints")
 	ErrNoAvailableKey       = errors.New("heque_redis_client: no available key")
+	ErrNoAvailableJob       = errors.New("heque_redis_client: no available job")
+	ErrJobNotRunning        = errors.New("heque_redis_client: job is not running")
+	ErrJobNotPending        = errors.New("heque_redis_client: job is not pending")
+	ErrNoResult             = errors.New("heque_redis_client: no result")
+	ErrInvalidProgress      = errors.New("heque_redis_client: progress must be between 0 and 100")
+	ErrScheduleRunClaimed   = errors.New("heque_redis_client: schedule run already claimed")
+	ErrUnknownDependency    = errors.New("heque_redis_client: unknown dependency")
+	ErrJobNotFound          = errors.New("heque_redis_client: job not found")
+	ErrInvalidPhase         = errors.New("heque_redis_client: jobs are listed by pending, running or failed phase")
 )
 
 const (
 	hequeKeyJobs    = "registry:jobs:"
+	hequeKeySpecs   = "registry:specs:"
 	hequeKeyPending = "registry:pending:"
 	hequeKeyRunning = "registry:running:"
 	hequeKeyBatches = "registry:batches:"
+	hequeKeyResults = "registry:results:"
+	hequeKeyFailed  = "registry:failed:"
+
+	// hequeKeyActiveBatches indexes the batches which may have pending or
+	// running jobs, see collector.
+	hequeKeyActiveBatches = "registry:activebatches"
+
+	hequeKeyCheckpoints = "registry:checkpoints:"
+
+	hequeKeyDependencies = "registry:dependencies:"
+	hequeKeyDependents   = "registry:dependents:"
+
+	hequeKeyLocks        = "registry:locks:"
+	hequeKeyJobLocks     = "registry:joblocks:"
+	hequeKeyLockWaiters  = "registry:lockwaiters:"
+	hequeKeyParked       = "registry:parked:"
+	hequeKeySchedules    = "registry:schedules"
+	hequeKeyScheduleRuns = "registry:scheduleruns:"
+
+	hequeKeyRateLimits = "registry:ratelimits:"
+
+	hequeKeyWorkers     = "registry:workers:"
+	hequeKeyWorkerJobs  = "registry:workerjobs:"
+	hequeKeyWorkerIndex = "registry:workerindex"
+	hequeKeyOrphans     = "registry:orphans"
+
+	hequeChannelQueueEvents = "registry:events:queues:"
+	hequeChannelBatchEvents = "registry:events:batches:"
+	hequeChannelJobEvents   = "registry:events:jobs:"
 )
 
 type Client struct {
 	redis   *redis.Client
 	keyFunc func(key string, name string) (string, error)
+	logger  *zap.Logger
 }
 
 func New(cfg Config) (*Client, error) {
@@ -36,12 +80,28 @@ func New(cfg Config) (*Client, error) {
 	// redis := newRedisClusterClient(cfg.Endpoints)
 	redisClient := newRedisClient(cfg.Endpoints)
 
+	logger := cfg.Logger
+	if logger == nil {
+		logger = zap.L()
+	}
+
 	return &Client{
 		redis:   redisClient,
 		keyFunc: DefaultKeyFunc,
+		logger:  logger.Named("client"),
 	}, nil
 }
 
+// jobLogger returns the logger of the client with the fields of job.
+func (c *Client) jobLogger(job *Job) *zap.Logger {
+	return c.logger.With(
+		zap.String("job", job.ID),
+		zap.String("queue", job.Spec.QueueName),
+		zap.String("batch", job.Spec.Batch),
+		zap.Int("attempt", job.Status.Attempts),
+	)
+}
+
 func DefaultKeyFunc(key string, name string) (string, error) {
 	if len(key) == 0 || len(name) == 0 {
 		return "", ErrNoAvailableKey
@@ -89,128 +149,304 @@ func newRedisClient(endpoints []string) *redis.Client {
 	return gClient
 }
 
+// Ping checks that redis is reachable.
+func (c *Client) Ping() error {
+	return c.redis.Ping().Err()
+}
+
+// Close closes the connections to redis.
+func (c *Client) Close() error {
+	return c.redis.Close()
+}
+
 // Enqueue
 func (c *Client) Enqueue(spec JobSpec) (*Job, error) {
-	// 生成job id
-	jobID := uuid.New().String()
+	return c.EnqueueContext(context.Background(), spec)
+}
+
+// EnqueueContext is like Enqueue but records the trace context of ctx in the
+// job, so that the worker continues the trace of the caller.
+func (c *Client) EnqueueContext(ctx context.Context, spec JobSpec) (*Job, error) {
+	if spec.TraceContext == nil {
+		spec.TraceContext = tracing.Inject(ctx)
+	}
+
+	if err := c.checkDependencies(&spec); err != nil {
+		return nil, err
+	}
 
 	// ****************
 	// 开始redis事务
 	// ****************
 	pipe := c.redis.TxPipeline()
-	// job key value
-	jobKey, err := c.keyFunc(hequeKeyJobs, jobID)
+	job, wait, err := c.enqueue(pipe, spec)
 	if err != nil {
 		pipe.Discard()
 		return nil, err
 	}
 
+	// ****************
+	// redis事务结束
+	// ****************
+	_, err = pipe.Exec()
+	if err != nil {
+		pipe.Discard()
+		return nil, err
+	}
+
+	if err := c.enqueued(job, wait); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// enqueue adds to pipe the enqueueing of a new job, and returns the job. For
+// a job with dependencies, it also returns the outcome of
+// waitForDependencies, to be handed to enqueued once pipe is executed.
+func (c *Client) enqueue(pipe redis.Pipeliner, spec JobSpec) (*Job, *redis.Cmd, error) {
+	// 生成job id
+	jobID := uuid.New().String()
+	now := time.Now()
+
+	traceContext, err := json.Marshal(spec.TraceContext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// job key value
+	jobKey, err := c.keyFunc(hequeKeyJobs, jobID)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	res := pipe.Set(jobKey, spec.Payload, 0*time.Second)
 	if res.Err() != nil {
-		pipe.Discard()
-		return nil, res.Err()
+		return nil, nil, res.Err()
+	}
+
+	// job spec
+	specKey, err := c.keyFunc(hequeKeySpecs, jobID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	specFields := map[string]interface{}{
+		"queue":      spec.QueueName,
+		"batch":      spec.Batch,
+		"timeout":    spec.Timeout.String(),
+		"maxRetries": spec.MaxRetries,
+		"trace":      string(traceContext),
+		"enqueued":   now.Format(time.RFC3339Nano),
+		"resultTTL":  spec.ResultTTL.String(),
+	}
+	if len(spec.DependsOn) > 0 {
+		dependsOn, err := json.Marshal(spec.DependsOn)
+		if err != nil {
+			return nil, nil, err
+		}
+		specFields["dependsOn"] = string(dependsOn)
+	}
+	if spec.LockKey != "" {
+		specFields["lockKey"] = spec.LockKey
+	}
+	if spec.OnSuccess != nil {
+		onSuccess, err := json.Marshal(spec.OnSuccess)
+		if err != nil {
+			return nil, nil, err
+		}
+		specFields["onSuccess"] = string(onSuccess)
+	}
+	if spec.OnFailure != nil {
+		onFailure, err := json.Marshal(spec.OnFailure)
+		if err != nil {
+			return nil, nil, err
+		}
+		specFields["onFailure"] = string(onFailure)
+	}
+
+	bres := pipe.HMSet(specKey, specFields)
+	if bres.Err() != nil {
+		return nil, nil, bres.Err()
 	}
 
 	// pending queue key, push to pending
 	queueKey, err := c.keyFunc(hequeKeyPending, spec.QueueName)
 	if err != nil {
-		pipe.Discard()
-		return nil, err
+		return nil, nil, err
 	}
 
-	lres := pipe.LPush(queueKey, jobID)
-	if lres.Err() != nil {
-		pipe.Discard()
-		return nil, lres.Err()
+	// jobs with dependencies are queued by waitForDependencies
+	if len(spec.DependsOn) == 0 {
+		lres := pipe.LPush(queueKey, jobID)
+		if lres.Err() != nil {
+			return nil, nil, lres.Err()
+		}
 	}
 
 	// batch count 批次统计
 	batchKey, err := c.keyFunc(hequeKeyBatches, spec.Batch)
 	if err != nil {
-		pipe.Discard()
-		return nil, err
+		return nil, nil, err
 	}
 	hres := pipe.HIncrBy(batchKey, "pending", 1)
 	if hres.Err() != nil {
-		pipe.Discard()
-		return nil, hres.Err()
-	}
-
-	// ****************
-	// redis事务结束
-	// ****************
-	_, err = pipe.Exec()
-	if err != nil {
-		pipe.Discard()
-		return nil, err
+		return nil, nil, hres.Err()
 	}
+	pipe.SAdd(hequeKeyActiveBatches, spec.Batch)
 
 	var job = &Job{
 		ID:   jobID,
 		Spec: spec,
 		Status: JobStatus{
-			Phase: JobPending,
+			Phase:       JobPending,
+			EnqueueTime: &now,
 		},
 	}
 
-	return job, nil
+	var wait *redis.Cmd
+	if len(spec.DependsOn) > 0 {
+		if wait, err = c.waitForDependencies(pipe, job); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := c.publish(pipe, jobEvent(EventEnqueued, job)); err != nil {
+		return nil, nil, err
+	}
+	return job, wait, nil
+}
+
+// enqueued completes the enqueueing of job once the pipeline built by
+// enqueue is executed.
+func (c *Client) enqueued(job *Job, wait *redis.Cmd) error {
+	jobsEnqueued.WithLabelValues(job.Spec.QueueName).Inc()
+
+	if wait != nil {
+		return c.waited(job, wait)
+	}
+	return nil
 }
 
 // Dequeue
 func (c *Client) Dequeue(queueName string) (*Job, error) {
+	return c.DequeueTimeout(queueName, 0*time.Second)
+}
+
+// DequeueTimeout is like Dequeue but gives up after timeout, returning
+// ErrNoAvailableJob. A zero timeout blocks forever. If the queue has a rate
+// limit, a dequeued job is only kept once the limit allows one more job.
+func (c *Client) DequeueTimeout(queueName string, timeout time.Duration) (*Job, error) {
+	return c.dequeue(queueName, timeout, true)
+}
+
+// TryDequeue is like Dequeue but does not block: it returns
+// ErrNoAvailableJob at once if the queue has no pending job, or if its rate
+// limit does not allow one more job yet.
+func (c *Client) TryDequeue(queueName string) (*Job, error) {
+	return c.dequeue(queueName, 0, false)
+}
+
+// dequeue implements DequeueTimeout, and TryDequeue if not blocking.
+func (c *Client) dequeue(queueName string, timeout time.Duration, blocking bool) (*Job, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
 	// 判断是否有pending jobs
 	// 如果pending没有，阻塞
 	pendingKey, err := c.keyFunc(hequeKeyPending, queueName)
 	if err != nil {
-		log.Println(err)
+		c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
 		return nil, err
 	}
 
 	runningKey, err := c.keyFunc(hequeKeyRunning, queueName)
 	if err != nil {
-		log.Println(err)
+		c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
 		return nil, err
 	}
 
-	pendingJobString := c.redis.BRPopLPush(pendingKey, runningKey, 0*time.Second)
-	if pendingJobString.Err() != nil {
-		log.Println(pendingJobString.Err())
-		return nil, pendingJobString.Err()
-	}
+	// a job whose lock is held by another job is parked, see lockJob, and
+	// the next pending job is tried instead
+	var jobID string
+	for jobID == "" {
+		var block time.Duration
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, ErrNoAvailableJob
+			}
+			// redis blocks for whole seconds, one at least, and the
+			// timeout would be truncated
+			block = (remaining + time.Second - 1).Truncate(time.Second)
+		}
 
-	pendingJobId := pendingJobString.Val()
+		var pendingJobString *redis.StringCmd
+		if blocking {
+			pendingJobString = c.redis.BRPopLPush(pendingKey, runningKey, block)
+		} else {
+			pendingJobString = c.redis.RPopLPush(pendingKey, runningKey)
+		}
+		if pendingJobString.Err() == redis.Nil {
+			return nil, ErrNoAvailableJob
+		}
+		if pendingJobString.Err() != nil {
+			c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(pendingJobString.Err()))
+			return nil, pendingJobString.Err()
+		}
+
+		locked, err := c.lockJob(queueName, pendingJobString.Val())
+		if err != nil {
+			c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
+			return nil, err
+		}
+		if !locked {
+			continue
+		}
 
-	jobKey, err := c.keyFunc(hequeKeyJobs, pendingJobId)
+		// the token is taken once there is a job to run, so that idle
+		// workers and parked jobs do not use any
+		wait, err := c.takeToken(queueName)
+		if err == nil && wait > 0 {
+			// put the job back, to be dequeued next
+			err = c.putBack(queueName, pendingJobString.Val())
+		}
+		if err != nil {
+			c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
+			return nil, err
+		}
+		if wait > 0 && !blocking {
+			return nil, ErrNoAvailableJob
+		}
+		if wait > 0 {
+			if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+				time.Sleep(time.Until(deadline))
+				return nil, ErrNoAvailableJob
+			}
+			time.Sleep(wait)
+			continue
+		}
+		jobID = pendingJobString.Val()
+	}
+
+	specKey, err := c.keyFunc(hequeKeySpecs, jobID)
 	if err != nil {
-		log.Println(err)
+		c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
 		return nil, err
 	}
 
-	jobString := c.redis.Get(jobKey)
-	if jobString.Err() != nil {
-		log.Println(jobString.Err())
-		return nil, jobString.Err()
+	if err := c.redis.HIncrBy(specKey, "attempts", 1).Err(); err != nil {
+		c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
+		return nil, err
 	}
 
-	// 获取job里的估值参数
-	jobStringMap := make(map[string]string)
-	err = json.Unmarshal([]byte(jobString.Val()), &jobStringMap)
+	job, err := c.getJob(queueName, jobID)
 	if err != nil {
-		log.Println(err)
+		c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
 		return nil, err
 	}
-
-	var job = &Job{
-		ID: pendingJobId,
-		Spec: JobSpec{
-			Payload:   jobString.Val(),
-			QueueName: queueName,
-			Batch:     jobStringMap["Batch"],
-		},
-		Status: JobStatus{
-			Phase: JobRunning,
-		},
-	}
+	job.Status.Phase = JobRunning
 
 	// ****************
 	// redis事务开始
@@ -225,226 +461,674 @@ func (c *Client) Dequeue(queueName string) (*Job, error) {
 
 		intCmd := pl.HIncrBy(batchKey, "running", 1)
 		if intCmd.Err() != nil {
-			log.Println(intCmd.Err())
+			c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(intCmd.Err()))
 			pl.Discard()
 			return nil, intCmd.Err()
 		}
 
 		intCmd = pl.HIncrBy(batchKey, "pending", -1)
 		if intCmd.Err() != nil {
-			log.Println(intCmd.Err())
+			c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(intCmd.Err()))
 			pl.Discard()
 			return nil, intCmd.Err()
 		}
 	}
 
+	if err := c.publish(pl, jobEvent(EventStarted, job)); err != nil {
+		c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
+		pl.Discard()
+		return nil, err
+	}
+
 	// ****************
 	// redis事务结束
 	// ****************
 	if _, err := pl.Exec(); err != nil {
-		log.Println(err)
+		c.logger.Error("failed to dequeue", zap.String("queue", queueName), zap.Error(err))
 		pl.Discard()
 		return nil, err
 	}
 	return job, nil
 }
 
-// MarkAsDone
-func (c *Client) MarkAsDone(job *Job) error {
-	// ****************
-	// redis事务开始
-	// ****************
-	pl := c.redis.TxPipeline()
-	batchKey, err := c.keyFunc(hequeKeyBatches, job.Spec.Batch)
+// getJob loads the job stored under id. For jobs enqueued without a stored
+// spec, the batch is read from the "Batch" field of the payload.
+func (c *Client) getJob(queueName string, id string) (*Job, error) {
+	jobKey, err := c.keyFunc(hequeKeyJobs, id)
 	if err != nil {
-		log.Println(err)
-		pl.Discard()
-		return err
+		return nil, err
 	}
 
-	intCmd := pl.HIncrBy(batchKey, "running", -1)
-	if intCmd.Err() != nil {
-		log.Println(intCmd.Err())
-		pl.Discard()
-		return intCmd.Err()
+	jobString := c.redis.Get(jobKey)
+	if jobString.Err() != nil {
+		return nil, jobString.Err()
 	}
 
-	intCmd = pl.HIncrBy(batchKey, "done", 1)
-	if intCmd.Err() != nil {
-		log.Println(intCmd.Err())
-		pl.Discard()
-		return intCmd.Err()
+	specKey, err := c.keyFunc(hequeKeySpecs, id)
+	if err != nil {
+		return nil, err
 	}
 
-	jobKey, err := c.keyFunc(hequeKeyJobs, job.ID)
+	specMap, err := c.redis.HGetAll(specKey).Result()
 	if err != nil {
-		log.Println(err)
-		return err
+		return nil, err
 	}
 
-	intCmd = pl.Del(jobKey)
-	if intCmd.Err() != nil {
-		log.Println(intCmd.Err())
-		pl.Discard()
-		return intCmd.Err()
+	job := &Job{
+		ID: id,
+		Spec: JobSpec{
+			Payload:   jobString.Val(),
+			QueueName: queueName,
+		},
+	}
+
+	if v, ok := specMap["queue"]; ok {
+		job.Spec.QueueName = v
+	}
+	if batch, ok := specMap["batch"]; ok {
+		job.Spec.Batch = batch
+	} else {
+		// 获取job里的估值参数
+		jobStringMap := make(map[string]string)
+		err = json.Unmarshal([]byte(jobString.Val()), &jobStringMap)
+		if err != nil {
+			return nil, err
+		}
+		job.Spec.Batch = jobStringMap["Batch"]
+	}
+
+	if v, ok := specMap["timeout"]; ok {
+		if job.Spec.Timeout, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := specMap["maxRetries"]; ok {
+		if job.Spec.MaxRetries, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := specMap["trace"]; ok {
+		if err = json.Unmarshal([]byte(v), &job.Spec.TraceContext); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := specMap["resultTTL"]; ok {
+		if job.Spec.ResultTTL, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := specMap["enqueued"]; ok {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, err
+		}
+		job.Status.EnqueueTime = &t
+	}
+	if v, ok := specMap["attempts"]; ok {
+		if job.Status.Attempts, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := specMap["progress"]; ok {
+		if job.Status.Progress, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	job.Status.ProgressMessage = specMap["progressMessage"]
+	job.Spec.LockKey = specMap["lockKey"]
+	if v, ok := specMap["dependsOn"]; ok {
+		if err = json.Unmarshal([]byte(v), &job.Spec.DependsOn); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := specMap["onSuccess"]; ok {
+		if err = json.Unmarshal([]byte(v), &job.Spec.OnSuccess); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := specMap["onFailure"]; ok {
+		if err = json.Unmarshal([]byte(v), &job.Spec.OnFailure); err != nil {
+			return nil, err
+		}
+	}
+
+	return job, nil
+}
+
+// GetJob returns a job of queueName, whether it is pending, waiting, running
+// or finished. It returns ErrJobNotFound if there is no such job, or if the
+// job is finished and its result has expired.
+func (c *Client) GetJob(queueName string, id string) (*Job, error) {
+	job, err := c.getJob(queueName, id)
+	if err == redis.Nil {
+		res, err := c.GetResult(id)
+		if err == ErrNoResult {
+			return nil, ErrJobNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res.QueueName != queueName {
+			return nil, ErrJobNotFound
+		}
+		return &Job{
+			ID:   id,
+			Spec: JobSpec{QueueName: queueName},
+			Status: JobStatus{
+				Phase:          res.Phase,
+				CompletionTime: &res.CompletionTime,
+				Result:         res.Result,
+			},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.Spec.QueueName != queueName {
+		return nil, ErrJobNotFound
+	}
+
+	if job.Status.Phase, err = c.phase(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// phase returns the phase of a job which is not finished. A job parked
+// until its lock is released is pending.
+func (c *Client) phase(job *Job) (JobPhase, error) {
+	dependenciesKey, err := c.keyFunc(hequeKeyDependencies, job.ID)
+	if err != nil {
+		return "", err
+	}
+	waiting, err := c.redis.Exists(dependenciesKey).Result()
+	if err != nil {
+		return "", err
+	}
+	if waiting > 0 {
+		return JobWaiting, nil
 	}
 
-	//弹出running
 	runningKey, err := c.keyFunc(hequeKeyRunning, job.Spec.QueueName)
 	if err != nil {
-		log.Println(err)
+		return "", err
+	}
+	// there are no more running jobs than workers
+	running, err := c.redis.LRange(runningKey, 0, -1).Result()
+	if err != nil {
+		return "", err
+	}
+	for _, id := range running {
+		if id == job.ID {
+			return JobRunning, nil
+		}
+	}
+	return JobPending, nil
+}
+
+// checkRunning returns ErrJobNotRunning if the job id of queueName is not
+// running, or is running another attempt than attempts. It reads with tx,
+// which watches the running list and the spec of the job, so that the
+// transaction of tx fails if the job is finished or requeued meanwhile.
+func (c *Client) checkRunning(tx *redis.Tx, queueName string, id string, attempts int) error {
+	runningKey, err := c.keyFunc(hequeKeyRunning, queueName)
+	if err != nil {
+		return err
+	}
+	specKey, err := c.keyFunc(hequeKeySpecs, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Watch(runningKey, specKey).Err(); err != nil {
 		return err
 	}
 
-	stringCmd := pl.RPop(runningKey)
-	if stringCmd.Err() != nil {
-		log.Println(stringCmd.Err())
-		pl.Discard()
-		return stringCmd.Err()
+	// jobs enqueued without a stored spec have no attempts
+	n, err := tx.HGet(specKey, "attempts").Int()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if n != attempts {
+		return ErrJobNotRunning
 	}
 
-	// ****************
-	// redis事务结束
-	// ****************
-	if _, err := pl.Exec(); err != nil {
-		log.Println(err)
-		pl.Discard()
+	// there are no more running jobs than workers
+	running, err := tx.LRange(runningKey, 0, -1).Result()
+	if err != nil {
 		return err
 	}
-	return nil
+	for _, runningID := range running {
+		if runningID == id {
+			return nil
+		}
+	}
+	return ErrJobNotRunning
 }
 
-// MarkAsFailed
-func (c *Client) MarkAsFailed(job *Job) error {
-	// ****************
-	// redis事务开始
-	// ****************
-	pl := c.redis.TxPipeline()
-	batchKey, err := c.keyFunc(hequeKeyBatches, job.Spec.Batch)
+// Retry moves a running job back to the head of its pending queue, to be
+// dequeued again, and releases its lock. It returns ErrJobNotRunning if the
+// job is not running the attempt of job any more.
+func (c *Client) Retry(job *Job) error {
+	ok, err := c.requeue(job.Spec.QueueName, job.ID, job.Status.Attempts)
 	if err != nil {
-		log.Println(err)
-		pl.Discard()
+		c.jobLogger(job).Error("failed to retry job", zap.Error(err))
 		return err
 	}
-
-	intCmd := pl.HIncrBy(batchKey, "running", -1)
-	if intCmd.Err() != nil {
-		log.Println(intCmd.Err())
-		pl.Discard()
-		return intCmd.Err()
+	if !ok {
+		return ErrJobNotRunning
 	}
+	c.publishNow(jobEvent(EventRetried, job))
+	return nil
+}
 
-	intCmd = pl.HIncrBy(batchKey, "failed", 1)
-	if intCmd.Err() != nil {
-		log.Println(intCmd.Err())
-		pl.Discard()
-		return intCmd.Err()
+// maxTxRetries bounds the retries of a transaction of which the watched keys
+// were modified before it was executed.
+const maxTxRetries = 10
+
+// watch runs fn, which executes a transaction with tx, and runs it again
+// while the keys watched by tx are modified before the transaction is
+// executed.
+func (c *Client) watch(fn func(tx *redis.Tx) error) error {
+	var err error
+	for i := 0; i < maxTxRetries; i++ {
+		if err = c.redis.Watch(fn); err != redis.TxFailedErr {
+			return err
+		}
 	}
+	return err
+}
+
+// MarkAsDone
+//
+// The result of the job is job.Status.Result, which the caller sets before
+// marking the job as done: it is stored for the ResultTTL of the job and
+// returned by GetResult and WaitResult.
+//
+// It returns ErrJobNotRunning if the job is not running the attempt of job
+// any more, e.g. because it was already acknowledged, or requeued.
+func (c *Client) MarkAsDone(job *Job) error {
+	var (
+		followUp      *Job
+		wait, release *redis.Cmd
+		running       *redis.IntCmd
+		pending       *redis.StringCmd
+	)
+	err := c.watch(func(tx *redis.Tx) error {
+		// ****************
+		// redis事务开始
+		// ****************
+		// the job is finished only once, by the attempt which is running
+		if err := c.checkRunning(tx, job.Spec.QueueName, job.ID, job.Status.Attempts); err != nil {
+			return err
+		}
+
+		pl := tx.TxPipeline()
+		var err error
+		followUp, wait, err = c.enqueueFollowUp(pl, job, job.Spec.OnSuccess)
+		if err != nil {
+			return err
+		}
+
+		batchKey, err := c.keyFunc(hequeKeyBatches, job.Spec.Batch)
+		if err != nil {
+			return err
+		}
+
+		running = pl.HIncrBy(batchKey, "running", -1)
+		pending = pl.HGet(batchKey, "pending")
+		pl.HIncrBy(batchKey, "done", 1)
+
+		jobKey, err := c.keyFunc(hequeKeyJobs, job.ID)
+		if err != nil {
+			return err
+		}
+
+		specKey, err := c.keyFunc(hequeKeySpecs, job.ID)
+		if err != nil {
+			return err
+		}
+
+		checkpointKey, err := c.keyFunc(hequeKeyCheckpoints, job.ID)
+		if err != nil {
+			return err
+		}
+
+		pl.Del(jobKey, specKey, checkpointKey)
+
+		//弹出running
+		runningKey, err := c.keyFunc(hequeKeyRunning, job.Spec.QueueName)
+		if err != nil {
+			return err
+		}
+
+		pl.LRem(runningKey, 1, job.ID)
+
+		if err := c.storeResult(pl, job, JobSucceeded); err != nil {
+			return err
+		}
+
+		if err := c.unlockJob(tx, pl, job); err != nil {
+			return err
+		}
+
+		if release, err = c.releaseDependents(tx, pl, job); err != nil {
+			return err
+		}
+
+		if err := c.publish(pl, jobEvent(EventSucceeded, job)); err != nil {
+			return err
+		}
 
-	jobKey, err := c.keyFunc(hequeKeyJobs, job.ID)
+		// ****************
+		// redis事务结束
+		// ****************
+		_, err = pl.Exec()
+		return err
+	})
+	if err == ErrJobNotRunning {
+		c.jobLogger(job).Info("job is not running any more, not marking it as done")
+		return err
+	}
 	if err != nil {
-		log.Println(err)
+		c.jobLogger(job).Error("failed to mark job as done", zap.Error(err))
 		return err
 	}
-
-	intCmd = pl.Del(jobKey)
-	if intCmd.Err() != nil {
-		log.Println(intCmd.Err())
-		pl.Discard()
-		return intCmd.Err()
+	jobsDone.WithLabelValues(job.Spec.QueueName).Inc()
+	if followUp != nil {
+		c.followedUp(job, followUp, wait)
+	}
+	if batchCompleted(running, pending) {
+		c.publishBatchCompleted(job)
 	}
+	c.released(job, release)
+	return nil
+}
 
-	//弹出running
-	runningKey, err := c.keyFunc(hequeKeyRunning, job.Spec.QueueName)
+// MarkAsFailed
+//
+// It returns ErrJobNotRunning if the job is not running the attempt of job
+// any more, see MarkAsDone.
+func (c *Client) MarkAsFailed(job *Job) error {
+	var (
+		followUp     *Job
+		dependents   []*Job
+		wait, cancel *redis.Cmd
+		running      *redis.IntCmd
+		pending      *redis.StringCmd
+	)
+	err := c.watch(func(tx *redis.Tx) error {
+		// ****************
+		// redis事务开始
+		// ****************
+		// the job is finished only once, by the attempt which is running
+		if err := c.checkRunning(tx, job.Spec.QueueName, job.ID, job.Status.Attempts); err != nil {
+			return err
+		}
+
+		pl := tx.TxPipeline()
+		var err error
+		followUp, wait, err = c.enqueueFollowUp(pl, job, job.Spec.OnFailure)
+		if err != nil {
+			return err
+		}
+
+		batchKey, err := c.keyFunc(hequeKeyBatches, job.Spec.Batch)
+		if err != nil {
+			return err
+		}
+
+		running = pl.HIncrBy(batchKey, "running", -1)
+		pending = pl.HGet(batchKey, "pending")
+		pl.HIncrBy(batchKey, "failed", 1)
+
+		jobKey, err := c.keyFunc(hequeKeyJobs, job.ID)
+		if err != nil {
+			return err
+		}
+
+		specKey, err := c.keyFunc(hequeKeySpecs, job.ID)
+		if err != nil {
+			return err
+		}
+
+		checkpointKey, err := c.keyFunc(hequeKeyCheckpoints, job.ID)
+		if err != nil {
+			return err
+		}
+
+		pl.Del(jobKey, specKey, checkpointKey)
+
+		//弹出running
+		runningKey, err := c.keyFunc(hequeKeyRunning, job.Spec.QueueName)
+		if err != nil {
+			return err
+		}
+
+		pl.LRem(runningKey, 1, job.ID)
+
+		if err := c.storeResult(pl, job, JobFailed); err != nil {
+			return err
+		}
+
+		if err := c.recordFailure(pl, job); err != nil {
+			return err
+		}
+
+		if err := c.unlockJob(tx, pl, job); err != nil {
+			return err
+		}
+
+		if dependents, cancel, err = c.cancelDependents(tx, pl, job); err != nil {
+			return err
+		}
+
+		if err := c.publish(pl, jobEvent(EventFailed, job)); err != nil {
+			return err
+		}
+
+		// ****************
+		// redis事务结束
+		// ****************
+		_, err = pl.Exec()
+		return err
+	})
+	if err == ErrJobNotRunning {
+		c.jobLogger(job).Info("job is not running any more, not marking it as failed")
+		return err
+	}
 	if err != nil {
-		log.Println(err)
+		c.jobLogger(job).Error("failed to mark job as failed", zap.Error(err))
 		return err
 	}
+	jobsFailed.WithLabelValues(job.Spec.QueueName).Inc()
+	if followUp != nil {
+		c.followedUp(job, followUp, wait)
+	}
+	if batchCompleted(running, pending) {
+		c.publishBatchCompleted(job)
+	}
+	remaining, _ := cancel.Val().([]interface{})
+	c.cancelledDependents(job, dependents, remaining)
+	return nil
+}
 
-	stringCmd := pl.RPop(runningKey)
-	if stringCmd.Err() != nil {
-		log.Println(stringCmd.Err())
-		pl.Discard()
-		return stringCmd.Err()
+// cancelScript removes a job from its pending queue, only if it is still
+// pending or waiting for its dependencies, counts it as cancelled in its
+// batch, and cancels the jobs depending on it, see cancelDependentsLua. It
+// returns -1 if the job was neither pending nor waiting, or else the number
+// of jobs of the batch still pending or running, followed by the numbers
+// returned by cancelDependentsLua. KEYS: pending, job, spec, batch,
+// checkpoint, dependencies, then those of cancelDependentsLua. ARGV: job id,
+// then those of cancelDependentsLua.
+var cancelScript = redis.NewScript(cancelDependentsLua + `
+local base = #KEYS - 5 * #ARGV + 5
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 0 and redis.call("DEL", KEYS[6]) == 0
+		and (base == 7 or redis.call("LREM", KEYS[7], 1, ARGV[1]) == 0) then
+	return {-1}
+end
+if base == 9 then
+	redis.call("LREM", KEYS[8], 1, ARGV[1])
+end
+redis.call("DEL", KEYS[2], KEYS[3], KEYS[5])
+local pending = redis.call("HINCRBY", KEYS[4], "pending", -1)
+redis.call("HINCRBY", KEYS[4], "cancelled", 1)
+local cancelled = cancelDependents(base)
+table.insert(cancelled, 1, pending + (tonumber(redis.call("HGET", KEYS[4], "running")) or 0))
+return cancelled
+`)
+
+// Cancel removes a pending, parked or waiting job from its queue, so that it
+// is never run, and cancels the jobs depending on it. It returns
+// ErrJobNotPending if the job has already been dequeued.
+func (c *Client) Cancel(job *Job) error {
+	var keys []string
+	for _, k := range [][2]string{
+		{hequeKeyPending, job.Spec.QueueName},
+		{hequeKeyJobs, job.ID},
+		{hequeKeySpecs, job.ID},
+		{hequeKeyBatches, job.Spec.Batch},
+		{hequeKeyCheckpoints, job.ID},
+		{hequeKeyDependencies, job.ID},
+		{hequeKeyLockWaiters, job.Spec.LockKey},
+		{hequeKeyParked, job.Spec.QueueName},
+	} {
+		if k[0] == hequeKeyLockWaiters && job.Spec.LockKey == "" {
+			break
+		}
+		key, err := c.keyFunc(k[0], k[1])
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
 	}
 
-	// ****************
-	// redis事务结束
-	// ****************
-	if _, err := pl.Exec(); err != nil {
-		log.Println(err)
-		_ = pl.Discard()
+	var dependents []*Job
+	var cancel *redis.Cmd
+	err := c.watch(func(tx *redis.Tx) error {
+		var dependentsKeys []string
+		var args []interface{}
+		var err error
+		dependents, dependentsKeys, args, err = c.cancelDependentsKeys(tx, job)
+		if err != nil {
+			return err
+		}
+
+		pl := tx.TxPipeline()
+		// EVALSHA may not be used in a transaction, as the script might not
+		// be loaded
+		cancel = cancelScript.Eval(pl, append(keys[:len(keys):len(keys)], dependentsKeys...), args...)
+		_, err = pl.Exec()
+		return err
+	})
+	if err != nil {
+		c.jobLogger(job).Error("failed to cancel job", zap.Error(err))
 		return err
 	}
-	log.Println("房屋估值失败......jobId:" + job.ID)
+
+	remaining, _ := cancel.Val().([]interface{})
+	if len(remaining) == 0 {
+		return fmt.Errorf("heque_redis_client: unexpected reply %v", cancel.Val())
+	}
+	n, _ := remaining[0].(int64)
+	if n < 0 {
+		return ErrJobNotPending
+	}
+
+	c.cancelled(job, int(n))
+	c.cancelledDependents(job, dependents, remaining[1:])
 	return nil
 }
 
+// cancelled records the cancellation of job, of which the batch has
+// remaining jobs still pending or running.
+func (c *Client) cancelled(job *Job, remaining int) {
+	job.Status.Phase = JobCancelled
+
+	pl := c.redis.TxPipeline()
+	err := c.storeResult(pl, job, JobCancelled)
+	if err == nil {
+		err = c.publish(pl, jobEvent(EventCancelled, job))
+	}
+	if err == nil {
+		_, err = pl.Exec()
+	}
+	if err != nil {
+		// the job is cancelled anyway
+		c.jobLogger(job).Error("failed to record the cancellation of job", zap.Error(err))
+	}
+	if remaining == 0 {
+		c.publishBatchCompleted(job)
+	}
+}
+
 // progress
 func (c *Client) Progress(batch string) (float64, error) {
-	var progress float64
+	status, err := c.BatchStatus(batch)
+	if err != nil {
+		return 0, err
+	}
+	return status.Progress(), nil
+}
 
+// BatchStatus returns the counters of a batch.
+func (c *Client) BatchStatus(batch string) (*BatchStatus, error) {
 	batchKey, err := c.keyFunc(hequeKeyBatches, batch)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	jobStringMap := c.redis.HGetAll(batchKey)
 	if jobStringMap.Err() != nil {
-		return 0, jobStringMap.Err()
+		return nil, jobStringMap.Err()
 	}
 
 	var j *BatchCount
 	jsonBytes, err := json.Marshal(jobStringMap.Val())
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	err = json.Unmarshal(jsonBytes, &j)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	var done = 0
-	var pending = 0
-	var running = 0
-	var failed = 0
+	var status BatchStatus
 
 	if j.Done != nil {
-		done, err = strconv.Atoi(*j.Done)
+		status.Done, err = strconv.Atoi(*j.Done)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
 
 	if j.Pending != nil {
-		pending, err = strconv.Atoi(*j.Pending)
+		status.Pending, err = strconv.Atoi(*j.Pending)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
 
 	if j.Running != nil {
-		running, err = strconv.Atoi(*j.Running)
+		status.Running, err = strconv.Atoi(*j.Running)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
 
 	if j.Failed != nil {
-		failed, err = strconv.Atoi(*j.Failed)
+		status.Failed, err = strconv.Atoi(*j.Failed)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
 
-	// 计算进度
-	total := done + pending + running + failed
-	if total == 0 {
-		progress = 1
-	} else {
-		progress = float64(done+failed) / float64(total)
+	if j.Cancelled != nil {
+		status.Cancelled, err = strconv.Atoi(*j.Cancelled)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return progress, nil
+	return &status, nil
 }
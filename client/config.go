@@ -1,6 +1,11 @@
 package client
 
+import "go.uber.org/zap"
+
 type Config struct {
 	// Endpoints is a list of URLs.
 	Endpoints []string `json:"endpoints"`
+
+	// Logger is the logger of the client. If nil, the global logger is used.
+	Logger *zap.Logger `json:"-"`
 }
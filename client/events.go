@@ -0,0 +1,261 @@
+package client
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"go.uber.org/zap"
+)
+
+// EventType is the kind of a job lifecycle event.
+type EventType string
+
+// These are the events published by the client.
+const (
+	// EventEnqueued is published when a job is enqueued.
+	EventEnqueued EventType = "enqueued"
+	// EventStarted is published when a job is dequeued by a worker.
+	EventStarted EventType = "started"
+	// EventSucceeded is published when a job is marked as done.
+	EventSucceeded EventType = "succeeded"
+	// EventFailed is published when a job is marked as failed.
+	EventFailed EventType = "failed"
+	// EventRetried is published when a failed job is moved back to pending.
+	EventRetried EventType = "retried"
+	// EventProgress is published when a running job reports its progress.
+	EventProgress EventType = "progress"
+	// EventCancelled is published when a pending job is cancelled.
+	EventCancelled EventType = "cancelled"
+	// EventBatchCompleted is published when the last pending or running job
+	// of a batch finishes.
+	EventBatchCompleted EventType = "batchCompleted"
+)
+
+// Event is a job lifecycle event. Every event is published on the channel of
+// the queue of the job, on the channel of its batch and on the channel of the
+// job itself.
+type Event struct {
+	Type      EventType `json:"type"`
+	JobID     string    `json:"jobID,omitempty"`
+	QueueName string    `json:"queueName"`
+	Batch     string    `json:"batch"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Time      time.Time `json:"time"`
+	// Progress and Message are reported by EventProgress events.
+	Progress int    `json:"progress,omitempty"`
+	Message  string `json:"message,omitempty"`
+	// BatchStatus holds the final counters of the batch of a
+	// EventBatchCompleted event.
+	BatchStatus *BatchStatus `json:"batchStatus,omitempty"`
+}
+
+// EventFilter selects the events delivered by a Subscription. Empty fields
+// select everything.
+type EventFilter struct {
+	QueueNames []string
+	Batches    []string
+	Types      []EventType
+}
+
+func (f *EventFilter) match(e *Event) bool {
+	if len(f.QueueNames) > 0 && e.Type != EventBatchCompleted && !contains(f.QueueNames, e.QueueName) {
+		return false
+	}
+	if len(f.Batches) > 0 && !contains(f.Batches, e.Batch) {
+		return false
+	}
+	if len(f.Types) > 0 {
+		for _, t := range f.Types {
+			if t == e.Type {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription delivers the events matching a filter until it is closed.
+type Subscription struct {
+	pubsub *redis.PubSub
+	events chan *Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Events returns the channel of the events. It is closed when the
+// subscription is closed.
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return s.pubsub.Close()
+}
+
+// Subscribe subscribes to the events selected by filter. The events
+// published after Subscribe returns are delivered, in order, until the
+// subscription is closed. The subscriber must keep up: when the buffer of
+// the subscription stays full, redis drops the events.
+func (c *Client) Subscribe(filter EventFilter) (*Subscription, error) {
+	var pubsub *redis.PubSub
+	switch {
+	case len(filter.Batches) > 0:
+		channels, err := c.channels(hequeChannelBatchEvents, filter.Batches)
+		if err != nil {
+			return nil, err
+		}
+		pubsub = c.redis.Subscribe(channels...)
+	case len(filter.QueueNames) > 0:
+		channels, err := c.channels(hequeChannelQueueEvents, filter.QueueNames)
+		if err != nil {
+			return nil, err
+		}
+		pubsub = c.redis.Subscribe(channels...)
+	default:
+		// every event is published on the channel of its queue
+		pubsub = c.redis.PSubscribe(hequeChannelQueueEvents + "*")
+	}
+
+	// wait for the subscription to be confirmed
+	if _, err := pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	s := &Subscription{
+		pubsub: pubsub,
+		events: make(chan *Event, 100),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(s.events)
+		for msg := range pubsub.Channel() {
+			var e Event
+			if err := json.Unmarshal([]byte(msg.Payload), &e); err != nil {
+				c.logger.Error("failed to decode event", zap.String("channel", msg.Channel), zap.Error(err))
+				continue
+			}
+			if !filter.match(&e) {
+				continue
+			}
+			select {
+			case s.events <- &e:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (c *Client) channels(prefix string, names []string) ([]string, error) {
+	channels := make([]string, 0, len(names))
+	for _, name := range names {
+		channel, err := c.keyFunc(prefix, name)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// jobEvent returns an event of job.
+func jobEvent(t EventType, job *Job) *Event {
+	return &Event{
+		Type:      t,
+		JobID:     job.ID,
+		QueueName: job.Spec.QueueName,
+		Batch:     job.Spec.Batch,
+		Attempt:   job.Status.Attempts,
+		Time:      time.Now(),
+	}
+}
+
+// publish adds the publication of e on the channels of its queue, batch and
+// job to pl, so that it is published along the change of state it reports.
+func (c *Client) publish(pl redis.Pipeliner, e *Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	queueChannel, err := c.keyFunc(hequeChannelQueueEvents, e.QueueName)
+	if err != nil {
+		return err
+	}
+	pl.Publish(queueChannel, payload)
+
+	if e.Batch != "" {
+		batchChannel, err := c.keyFunc(hequeChannelBatchEvents, e.Batch)
+		if err != nil {
+			return err
+		}
+		pl.Publish(batchChannel, payload)
+	}
+
+	if e.JobID != "" {
+		jobChannel, err := c.keyFunc(hequeChannelJobEvents, e.JobID)
+		if err != nil {
+			return err
+		}
+		pl.Publish(jobChannel, payload)
+	}
+	return nil
+}
+
+// publishNow publishes e outside of any transaction. Failures are logged,
+// since the change of state has already happened.
+func (c *Client) publishNow(e *Event) {
+	pl := c.redis.Pipeline()
+	err := c.publish(pl, e)
+	if err == nil {
+		_, err = pl.Exec()
+	}
+	if err != nil {
+		c.logger.Error("failed to publish event", zap.String("type", string(e.Type)), zap.String("job", e.JobID), zap.Error(err))
+	}
+}
+
+// publishBatchCompleted publishes EventBatchCompleted for the batch of job,
+// with the final counters of the batch.
+func (c *Client) publishBatchCompleted(job *Job) {
+	status, err := c.BatchStatus(job.Spec.Batch)
+	if err != nil {
+		c.logger.Error("failed to read batch status", zap.String("batch", job.Spec.Batch), zap.Error(err))
+		return
+	}
+
+	c.publishNow(&Event{
+		Type:        EventBatchCompleted,
+		QueueName:   job.Spec.QueueName,
+		Batch:       job.Spec.Batch,
+		Time:        time.Now(),
+		BatchStatus: status,
+	})
+}
+
+// batchCompleted reports whether the counters of a batch read in the
+// transaction which finished one of its jobs show no pending or running job.
+// Only the transaction finishing the last job sees both at zero.
+func batchCompleted(running *redis.IntCmd, pending *redis.StringCmd) bool {
+	n, err := pending.Int()
+	if err != nil && err != redis.Nil {
+		return false
+	}
+	return running.Val() == 0 && n == 0
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"go.uber.org/zap"
+)
+
+// tryLockScript takes a lock, or extends it if it is already held by the
+// same owner. It returns 1 if the owner holds the lock. KEYS: the lock.
+// ARGV: owner, ttl in milliseconds.
+var tryLockScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if not owner then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// unlockScript releases a lock, only if it is held by the owner. KEYS: the
+// lock. ARGV: owner.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// TryLock takes the named lock for owner, or extends it if owner already
+// holds it. The lock expires after ttl unless it is extended, so that it is
+// released when its owner dies. It reports whether owner holds the lock.
+func (c *Client) TryLock(name string, owner string, ttl time.Duration) (bool, error) {
+	lockKey, err := c.keyFunc(hequeKeyLocks, name)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := tryLockScript.Run(c.redis, []string{lockKey}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Unlock releases the named lock if it is held by owner.
+func (c *Client) Unlock(name string, owner string) error {
+	lockKey, err := c.keyFunc(hequeKeyLocks, name)
+	if err != nil {
+		return err
+	}
+
+	return unlockScript.Run(c.redis, []string{lockKey}, owner).Err()
+}
+
+// DefaultJobLockTTL is how long the lock of a job is held after the job is
+// dequeued. The heartbeats of the worker running the job extend it.
+const DefaultJobLockTTL = 30 * time.Second
+
+// lockJobScript takes the lock of a dequeued job, or parks the job if the
+// lock is held by another job: the job is moved from the running list to
+// the waiters of the lock and to the parked jobs of its queue. It returns 1
+// if the job holds the lock. KEYS: lock, waiters, running, parked. ARGV: job
+// id, ttl in milliseconds.
+var lockJobScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if not owner or owner == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+redis.call("LREM", KEYS[3], 1, ARGV[1])
+redis.call("RPUSH", KEYS[2], ARGV[1])
+redis.call("RPUSH", KEYS[4], ARGV[1])
+return 0
+`)
+
+// unlockJobScript releases the lock of a job, if the job holds it or the
+// lock has expired, and moves the first job parked on the lock, if it is
+// still the first one, back to the head of its pending queue. KEYS: lock,
+// waiters and, if there is a parked job, its pending queue and the parked
+// jobs of its queue. ARGV: job id and the id of the parked job.
+var unlockJobScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner and owner ~= ARGV[1] then
+	return 0
+end
+redis.call("DEL", KEYS[1])
+if ARGV[2] and redis.call("LINDEX", KEYS[2], 0) == ARGV[2] then
+	redis.call("LPOP", KEYS[2])
+	if KEYS[3] then
+		redis.call("LREM", KEYS[4], 1, ARGV[2])
+		redis.call("RPUSH", KEYS[3], ARGV[2])
+	end
+end
+return 0
+`)
+
+// extendJobLocksScript extends the locks held by the given jobs. KEYS: the
+// locks. ARGV: ttl in milliseconds, the ids of the jobs holding the locks.
+var extendJobLocksScript = redis.NewScript(`
+for i = 1, #KEYS do
+	if redis.call("GET", KEYS[i]) == ARGV[i + 1] then
+		redis.call("PEXPIRE", KEYS[i], ARGV[1])
+	end
+end
+return 0
+`)
+
+// lockJob takes the lock of a job just moved to the running list of
+// queueName, if the job has a LockKey. It reports false if the job was
+// parked because another job holds the lock.
+func (c *Client) lockJob(queueName string, jobID string) (bool, error) {
+	specKey, err := c.keyFunc(hequeKeySpecs, jobID)
+	if err != nil {
+		return false, err
+	}
+
+	lockKey, err := c.redis.HGet(specKey, "lockKey").Result()
+	if err == redis.Nil || lockKey == "" {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var keys []string
+	for _, k := range [][2]string{
+		{hequeKeyJobLocks, lockKey},
+		{hequeKeyLockWaiters, lockKey},
+		{hequeKeyRunning, queueName},
+		{hequeKeyParked, queueName},
+	} {
+		key, err := c.keyFunc(k[0], k[1])
+		if err != nil {
+			return false, err
+		}
+		keys = append(keys, key)
+	}
+
+	n, err := lockJobScript.Run(c.redis, keys, jobID, DefaultJobLockTTL.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		c.logger.Debug("job parked until its lock is released",
+			zap.String("job", jobID), zap.String("queue", queueName), zap.String("lockKey", lockKey))
+	}
+	return n == 1, nil
+}
+
+// unlockJob adds to pl the release of the lock of job, see unlockJobScript.
+// The first job parked on the lock is read with tx, which watches the
+// waiters of the lock. It does nothing if the job has no LockKey.
+func (c *Client) unlockJob(tx *redis.Tx, pl redis.Pipeliner, job *Job) error {
+	if job.Spec.LockKey == "" {
+		return nil
+	}
+
+	lockKey, err := c.keyFunc(hequeKeyJobLocks, job.Spec.LockKey)
+	if err != nil {
+		return err
+	}
+	waitersKey, err := c.keyFunc(hequeKeyLockWaiters, job.Spec.LockKey)
+	if err != nil {
+		return err
+	}
+	if err := tx.Watch(waitersKey).Err(); err != nil {
+		return err
+	}
+
+	keys := []string{lockKey, waitersKey}
+	args := []interface{}{job.ID}
+	waiter, err := tx.LIndex(waitersKey, 0).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if waiter != "" {
+		specKey, err := c.keyFunc(hequeKeySpecs, waiter)
+		if err != nil {
+			return err
+		}
+		queueName, err := tx.HGet(specKey, "queue").Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if queueName != "" {
+			pendingKey, err := c.keyFunc(hequeKeyPending, queueName)
+			if err != nil {
+				return err
+			}
+			parkedKey, err := c.keyFunc(hequeKeyParked, queueName)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, pendingKey, parkedKey)
+		}
+		args = append(args, waiter)
+	}
+
+	// EVALSHA may not be used in a transaction, as the script might not be
+	// loaded
+	return unlockJobScript.Eval(pl, keys, args...).Err()
+}
+
+// extendJobLocks adds to pl the extension for ttl of the locks held by the
+// jobs, see extendJobLocksScript.
+func (c *Client) extendJobLocks(pl redis.Pipeliner, ids []string, ttl time.Duration) error {
+	specs := c.redis.Pipeline()
+	lockKeys := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		specKey, err := c.keyFunc(hequeKeySpecs, id)
+		if err != nil {
+			return err
+		}
+		lockKeys[i] = specs.HGet(specKey, "lockKey")
+	}
+	if _, err := specs.Exec(); err != nil && err != redis.Nil {
+		return err
+	}
+
+	var keys []string
+	args := []interface{}{ttl.Milliseconds()}
+	for i, id := range ids {
+		if lockKeys[i].Val() == "" {
+			continue
+		}
+		key, err := c.keyFunc(hequeKeyJobLocks, lockKeys[i].Val())
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		args = append(args, id)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	// EVALSHA may not be used in a transaction, as the script might not be
+	// loaded
+	return extendJobLocksScript.Eval(pl, keys, args...).Err()
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"github.com/go-redis/redis/v7"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	jobsEnqueued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "heque_jobs_enqueued_total",
+		Help: "Number of jobs enqueued.",
+	}, []string{"queue"})
+	jobsDone = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "heque_jobs_processed_total",
+		Help: "Number of jobs marked as done.",
+	}, []string{"queue"})
+	jobsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "heque_jobs_failed_total",
+		Help: "Number of jobs marked as failed.",
+	}, []string{"queue"})
+	jobsRequeued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "heque_jobs_requeued_total",
+		Help: "Number of running jobs moved back to pending, for a retry or because their worker died.",
+	}, []string{"queue"})
+)
+
+var (
+	queuePendingDesc = prometheus.NewDesc(
+		"heque_queue_pending_jobs",
+		"Number of pending jobs in the queue.",
+		[]string{"queue"}, nil)
+	queueRunningDesc = prometheus.NewDesc(
+		"heque_queue_running_jobs",
+		"Number of running jobs of the queue.",
+		[]string{"queue"}, nil)
+	batchJobsDesc = prometheus.NewDesc(
+		"heque_batch_jobs",
+		"Number of jobs of an unfinished batch, by state.",
+		[]string{"batch", "state"}, nil)
+	batchProgressDesc = prometheus.NewDesc(
+		"heque_batch_progress",
+		"Share of the jobs of an unfinished batch which are done, failed or cancelled.",
+		[]string{"batch"}, nil)
+)
+
+// RegisterMetrics registers the job counters of the clients of the process
+// with reg. Registering them again is not an error.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{jobsEnqueued, jobsDone, jobsFailed, jobsRequeued} {
+		if err := reg.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deactivateBatchScript removes a batch from the index of the active
+// batches if it has no pending or running job. KEYS: the index, the batch.
+// ARGV: the name of the batch.
+var deactivateBatchScript = redis.NewScript(`
+local pending = tonumber(redis.call("HGET", KEYS[2], "pending")) or 0
+local running = tonumber(redis.call("HGET", KEYS[2], "running")) or 0
+if pending + running > 0 then
+	return 0
+end
+return redis.call("SREM", KEYS[1], ARGV[1])
+`)
+
+// collector reads the length of the queues and the counters of the batches
+// from redis on every scrape.
+type collector struct {
+	client *Client
+}
+
+// NewCollector returns a Prometheus collector exporting the pending and
+// running jobs of every queue and the progress of every unfinished batch.
+// Since the values are shared by all the clients, a single process, e.g. the
+// apiserver, should register it.
+func NewCollector(c *Client) prometheus.Collector {
+	return &collector{client: c}
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queuePendingDesc
+	ch <- queueRunningDesc
+	ch <- batchJobsDesc
+	ch <- batchProgressDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.collectQueue(ch, hequeKeyPending, queuePendingDesc)
+	c.collectQueue(ch, hequeKeyRunning, queueRunningDesc)
+
+	batches, err := c.client.redis.SMembers(hequeKeyActiveBatches).Result()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(batchJobsDesc, err)
+		return
+	}
+	for _, batch := range batches {
+		status, err := c.client.BatchStatus(batch)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(batchJobsDesc, err)
+			continue
+		}
+		if status.Pending+status.Running == 0 {
+			// finished, unless jobs were added since it was read
+			if err := c.client.deactivateBatch(batch); err != nil {
+				ch <- prometheus.NewInvalidMetric(batchJobsDesc, err)
+			}
+			continue
+		}
+
+		for state, n := range map[JobPhase]int{
+			JobPending:   status.Pending,
+			JobRunning:   status.Running,
+			JobSucceeded: status.Done,
+			JobFailed:    status.Failed,
+			JobCancelled: status.Cancelled,
+		} {
+			ch <- prometheus.MustNewConstMetric(batchJobsDesc, prometheus.GaugeValue, float64(n), batch, string(state))
+		}
+		ch <- prometheus.MustNewConstMetric(batchProgressDesc, prometheus.GaugeValue, status.Progress(), batch)
+	}
+}
+
+// deactivateBatch removes batch from the index of the active batches if it
+// has no pending or running job, so that the finished batches are not read
+// on every scrape.
+func (c *Client) deactivateBatch(batch string) error {
+	batchKey, err := c.keyFunc(hequeKeyBatches, batch)
+	if err != nil {
+		return err
+	}
+	return deactivateBatchScript.Run(c.redis, []string{hequeKeyActiveBatches, batchKey}, batch).Err()
+}
+
+func (c *collector) collectQueue(ch chan<- prometheus.Metric, prefix string, desc *prometheus.Desc) {
+	queues, err := c.client.scanNames(prefix)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(desc, err)
+		return
+	}
+	for _, queueName := range queues {
+		key, err := c.client.keyFunc(prefix, queueName)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			continue
+		}
+
+		n, err := c.client.redis.LLen(key).Result()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(n), queueName)
+	}
+}
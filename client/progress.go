@@ -0,0 +1,104 @@
+package client
+
+import (
+	"github.com/go-redis/redis/v7"
+	"go.uber.org/zap"
+)
+
+// ReportProgress records how far a running job has got, in percent, with a
+// message describing the current step, and publishes it as an EventProgress
+// event. It is meant to be called by handlers between the steps of long
+// jobs. It returns ErrJobNotRunning if the job is not running the attempt of
+// job any more, e.g. because its handler overran its timeout.
+func (c *Client) ReportProgress(job *Job, percent int, message string) error {
+	if percent < 0 || percent > 100 {
+		return ErrInvalidProgress
+	}
+
+	specKey, err := c.keyFunc(hequeKeySpecs, job.ID)
+	if err != nil {
+		return err
+	}
+
+	job.Status.Progress = percent
+	job.Status.ProgressMessage = message
+
+	e := jobEvent(EventProgress, job)
+	e.Progress = percent
+	e.Message = message
+
+	err = c.watch(func(tx *redis.Tx) error {
+		// ****************
+		// redis事务开始
+		// ****************
+		// the spec of a finished job is not recreated
+		if err := c.checkRunning(tx, job.Spec.QueueName, job.ID, job.Status.Attempts); err != nil {
+			return err
+		}
+
+		pl := tx.TxPipeline()
+		pl.HMSet(specKey, map[string]interface{}{
+			"progress":        percent,
+			"progressMessage": message,
+		})
+		if err := c.publish(pl, e); err != nil {
+			return err
+		}
+
+		// ****************
+		// redis事务结束
+		// ****************
+		_, err := pl.Exec()
+		return err
+	})
+	if err != nil && err != ErrJobNotRunning {
+		c.jobLogger(job).Error("failed to report progress", zap.Error(err))
+	}
+	return err
+}
+
+// SaveCheckpoint records the state of a job after its last completed step.
+// The checkpoint survives retries, including the requeue of the jobs of a
+// dead worker, and is deleted when the job is finished. It expires after the
+// ResultTTL of the job otherwise. It returns ErrJobNotRunning if the job is
+// not running the attempt of job any more.
+func (c *Client) SaveCheckpoint(job *Job, checkpoint string) error {
+	checkpointKey, err := c.keyFunc(hequeKeyCheckpoints, job.ID)
+	if err != nil {
+		return err
+	}
+
+	err = c.watch(func(tx *redis.Tx) error {
+		// the checkpoint of a finished job is not recreated
+		if err := c.checkRunning(tx, job.Spec.QueueName, job.ID, job.Status.Attempts); err != nil {
+			return err
+		}
+
+		pl := tx.TxPipeline()
+		pl.Set(checkpointKey, checkpoint, resultTTL(job))
+		_, err := pl.Exec()
+		return err
+	})
+	if err != nil && err != ErrJobNotRunning {
+		c.jobLogger(job).Error("failed to save checkpoint", zap.Error(err))
+	}
+	return err
+}
+
+// LoadCheckpoint returns the last checkpoint saved by a previous attempt of
+// the job, or an empty string if there is none.
+func (c *Client) LoadCheckpoint(job *Job) (string, error) {
+	checkpointKey, err := c.keyFunc(hequeKeyCheckpoints, job.ID)
+	if err != nil {
+		return "", err
+	}
+
+	checkpoint, err := c.redis.Get(checkpointKey).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// recordFailure adds to pl the indexing of job, which failed, among the
+// failed jobs of its queue. The index is scored by the expiry of the result
+// of the job, and entries whose result has expired are pruned.
+func (c *Client) recordFailure(pl redis.Pipeliner, job *Job) error {
+	failedKey, err := c.keyFunc(hequeKeyFailed, job.Spec.QueueName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	pl.ZRemRangeByScore(failedKey, "-inf", strconv.FormatInt(now.Unix(), 10))
+	pl.ZAdd(failedKey, &redis.Z{Score: float64(now.Add(resultTTL(job)).Unix()), Member: job.ID})
+	return nil
+}
+
+// Queues lists the queues which have pending, running or failed jobs, or
+// consumers, sorted by name.
+func (c *Client) Queues() ([]*QueueStatus, error) {
+	queues := make(map[string]*QueueStatus)
+	status := func(name string) *QueueStatus {
+		q, ok := queues[name]
+		if !ok {
+			q = &QueueStatus{Name: name}
+			queues[name] = q
+		}
+		return q
+	}
+
+	for _, prefix := range []string{hequeKeyPending, hequeKeyParked, hequeKeyRunning, hequeKeyFailed} {
+		names, err := c.scanNames(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			status(name)
+		}
+	}
+
+	workers, err := c.Workers()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range workers {
+		for _, name := range w.Queues {
+			status(name).Consumers++
+		}
+	}
+
+	list := make([]*QueueStatus, 0, len(queues))
+	for _, q := range queues {
+		if err := c.countJobs(q); err != nil {
+			return nil, err
+		}
+		list = append(list, q)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// countJobs reads the number of pending, running and failed jobs of q. The
+// jobs parked until their lock is released are pending.
+func (c *Client) countJobs(q *QueueStatus) error {
+	var keys []string
+	for _, prefix := range []string{hequeKeyPending, hequeKeyRunning, hequeKeyFailed, hequeKeyParked} {
+		key, err := c.keyFunc(prefix, q.Name)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	pl := c.redis.Pipeline()
+	pending := pl.LLen(keys[0])
+	running := pl.LLen(keys[1])
+	failed := pl.ZCount(keys[2], strconv.FormatInt(time.Now().Unix(), 10), "+inf")
+	parked := pl.LLen(keys[3])
+	if _, err := pl.Exec(); err != nil {
+		return err
+	}
+
+	q.Pending = int(pending.Val() + parked.Val())
+	q.Running = int(running.Val())
+	q.Failed = int(failed.Val())
+	return nil
+}
+
+// QueueJobs returns at most limit jobs of queueName in phase, skipping the
+// first offset ones, and the number of jobs in phase. The phase is
+// JobPending, in the order the jobs are dequeued followed by the jobs parked
+// until their lock is released, JobRunning, or JobFailed, the latest first.
+// It returns ErrInvalidPhase for the other phases.
+func (c *Client) QueueJobs(queueName string, phase JobPhase, offset int, limit int) ([]*Job, int, error) {
+	var list []string
+	var total int
+	var err error
+	switch phase {
+	case JobPending:
+		list, total, err = c.pendingJobs(queueName, offset, limit)
+	case JobRunning, JobFailed:
+		list, total, err = c.runningOrFailedJobs(queueName, phase, offset, limit)
+	default:
+		return nil, 0, ErrInvalidPhase
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	jobs := make([]*Job, 0, len(list))
+	for _, id := range list {
+		var job *Job
+		if phase == JobFailed {
+			job, err = c.GetJob(queueName, id)
+		} else {
+			job, err = c.getJob(queueName, id)
+		}
+		if err == redis.Nil || err == ErrJobNotFound {
+			// finished, or expired, since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		job.Status.Phase = phase
+		jobs = append(jobs, job)
+	}
+	return jobs, total, nil
+}
+
+// pendingJobs returns the ids of at most limit pending jobs of queueName,
+// skipping the first offset ones, and the number of pending jobs, see
+// QueueJobs.
+func (c *Client) pendingJobs(queueName string, offset int, limit int) ([]string, int, error) {
+	pendingKey, err := c.keyFunc(hequeKeyPending, queueName)
+	if err != nil {
+		return nil, 0, err
+	}
+	parkedKey, err := c.keyFunc(hequeKeyParked, queueName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pl := c.redis.Pipeline()
+	pending := pl.LLen(pendingKey)
+	parked := pl.LLen(parkedKey)
+	if _, err := pl.Exec(); err != nil {
+		return nil, 0, err
+	}
+
+	var list []string
+	start, stop := int64(offset), int64(offset+limit-1)
+	if start < pending.Val() {
+		// the jobs are pushed to the head and dequeued from the tail
+		ids, err := c.redis.LRange(pendingKey, -stop-1, -start-1).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		for i := len(ids) - 1; i >= 0; i-- {
+			list = append(list, ids[i])
+		}
+	}
+	if stop >= pending.Val() {
+		if start < pending.Val() {
+			start = pending.Val()
+		}
+		ids, err := c.redis.LRange(parkedKey, start-pending.Val(), stop-pending.Val()).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, ids...)
+	}
+	return list, int(pending.Val() + parked.Val()), nil
+}
+
+// runningOrFailedJobs returns the ids of at most limit running or failed jobs of
+// queueName, skipping the first offset ones, and the number of jobs in
+// phase, see QueueJobs.
+func (c *Client) runningOrFailedJobs(queueName string, phase JobPhase, offset int, limit int) ([]string, int, error) {
+	prefix := hequeKeyRunning
+	if phase == JobFailed {
+		prefix = hequeKeyFailed
+	}
+	key, err := c.keyFunc(prefix, queueName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start, stop := int64(offset), int64(offset+limit-1)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	pl := c.redis.Pipeline()
+	var total *redis.IntCmd
+	var ids *redis.StringSliceCmd
+	if phase == JobRunning {
+		total = pl.LLen(key)
+		ids = pl.LRange(key, start, stop)
+	} else {
+		total = pl.ZCount(key, now, "+inf")
+		ids = pl.ZRevRangeByScore(key, &redis.ZRangeBy{
+			Min:    now,
+			Max:    "+inf",
+			Offset: start,
+			Count:  int64(limit),
+		})
+	}
+	if _, err := pl.Exec(); err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+	return ids.Val(), int(total.Val()), nil
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"go.uber.org/zap"
+)
+
+// takeTokenScript takes a token from the bucket of a queue. The bucket is
+// refilled at "rate" tokens per second, up to "burst" tokens. It returns 0
+// when a token was taken, or the number of milliseconds to wait for the next
+// one. The time is read from redis, as the clocks of the workers may differ.
+// KEYS: the bucket.
+var takeTokenScript = redis.NewScript(`
+-- the writes following TIME are replicated as such, not as the script
+redis.replicate_commands()
+local rate = tonumber(redis.call("HGET", KEYS[1], "rate"))
+if not rate or rate <= 0 then
+	return 0
+end
+local burst = tonumber(redis.call("HGET", KEYS[1], "burst")) or 1
+if burst < 1 then
+	burst = 1
+end
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local tokens = tonumber(redis.call("HGET", KEYS[1], "tokens"))
+local ts = tonumber(redis.call("HGET", KEYS[1], "ts"))
+if not tokens or not ts then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+	ts = now
+end
+if tokens >= 1 then
+	redis.call("HMSET", KEYS[1], "tokens", tostring(tokens - 1), "ts", tostring(ts))
+	return 0
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(ts))
+return math.ceil((1 - tokens) * 1000 / rate)
+`)
+
+// SetRateLimit limits the rate at which the jobs of a queue are dequeued,
+// collectively by all the workers of the queue. A zero rate removes the
+// limit.
+func (c *Client) SetRateLimit(queueName string, limit RateLimit) error {
+	rateLimitKey, err := c.keyFunc(hequeKeyRateLimits, queueName)
+	if err != nil {
+		return err
+	}
+
+	if limit.Rate <= 0 {
+		return c.redis.Del(rateLimitKey).Err()
+	}
+
+	res := c.redis.HMSet(rateLimitKey, map[string]interface{}{
+		"rate":  strconv.FormatFloat(limit.Rate, 'f', -1, 64),
+		"burst": limit.Burst,
+	})
+	if res.Err() != nil {
+		c.logger.Error("failed to set rate limit", zap.String("queue", queueName), zap.Error(res.Err()))
+		return res.Err()
+	}
+	return nil
+}
+
+// GetRateLimit returns the rate limit of a queue. A zero rate means the
+// queue is not limited.
+func (c *Client) GetRateLimit(queueName string) (*RateLimit, error) {
+	rateLimitKey, err := c.keyFunc(hequeKeyRateLimits, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := c.redis.HMGet(rateLimitKey, "rate", "burst").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var limit RateLimit
+	if s, ok := fields[0].(string); ok {
+		if limit.Rate, err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, err
+		}
+	}
+	if s, ok := fields[1].(string); ok {
+		if limit.Burst, err = strconv.Atoi(s); err != nil {
+			return nil, err
+		}
+	}
+	return &limit, nil
+}
+
+// takeToken takes a token from the bucket of the queue. It returns how long
+// to wait for the next token if there is none, or zero.
+func (c *Client) takeToken(queueName string) (time.Duration, error) {
+	rateLimitKey, err := c.keyFunc(hequeKeyRateLimits, queueName)
+	if err != nil {
+		return 0, err
+	}
+
+	ms, err := takeTokenScript.Run(c.redis, []string{rateLimitKey}).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
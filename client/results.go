@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// DefaultResultTTL is how long the result of a finished job is kept when its
+// spec does not say.
+const DefaultResultTTL = 24 * time.Hour
+
+// storeResult adds the recording of the outcome of job to pl, to be kept for
+// the ResultTTL of the job.
+func (c *Client) storeResult(pl redis.Pipeliner, job *Job, phase JobPhase) error {
+	resultKey, err := c.keyFunc(hequeKeyResults, job.ID)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"phase":     string(phase),
+		"queue":     job.Spec.QueueName,
+		"completed": time.Now().Format(time.RFC3339Nano),
+	}
+	if phase == JobSucceeded {
+		fields["result"] = job.Status.Result
+	}
+	pl.HMSet(resultKey, fields)
+	pl.Expire(resultKey, resultTTL(job))
+	return nil
+}
+
+// resultTTL returns how long the result of job is kept.
+func resultTTL(job *Job) time.Duration {
+	if job.Spec.ResultTTL <= 0 {
+		return DefaultResultTTL
+	}
+	return job.Spec.ResultTTL
+}
+
+// GetResult returns the outcome of a finished job. It returns ErrNoResult if
+// the job is not finished, or if its result has expired.
+func (c *Client) GetResult(jobID string) (*JobResult, error) {
+	resultKey, err := c.keyFunc(hequeKeyResults, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := c.redis.HGetAll(resultKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrNoResult
+	}
+
+	res := &JobResult{
+		Phase:     JobPhase(fields["phase"]),
+		QueueName: fields["queue"],
+		Result:    fields["result"],
+	}
+	if res.CompletionTime, err = time.Parse(time.RFC3339Nano, fields["completed"]); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// WaitResult waits for a job to finish and returns its outcome, or the error
+// of ctx if it is done first. Callers typically enqueue a job and wait for it
+// with a deadline.
+func (c *Client) WaitResult(ctx context.Context, jobID string) (*JobResult, error) {
+	jobChannel, err := c.keyFunc(hequeChannelJobEvents, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	// subscribe before reading the result, so that a job finishing in
+	// between is not missed
+	pubsub := c.redis.Subscribe(jobChannel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(); err != nil {
+		return nil, err
+	}
+	events := pubsub.Channel()
+
+	for {
+		res, err := c.GetResult(jobID)
+		if err != ErrNoResult {
+			return res, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-events:
+		}
+	}
+}
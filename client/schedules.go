@@ -0,0 +1,187 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"go.uber.org/zap"
+)
+
+// SetSchedule creates or replaces a schedule. The time of its last run, if
+// any, is kept, while the next run is computed again by the scheduler unless
+// the schedule is unchanged.
+func (c *Client) SetSchedule(s *Schedule) error {
+	runsKey, err := c.keyFunc(hequeKeyScheduleRuns, s.Name)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	old, err := c.redis.HGet(hequeKeySchedules, s.Name).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if old == string(buf) {
+		// unchanged, e.g. loaded again from a file
+		return nil
+	}
+
+	pl := c.redis.TxPipeline()
+	pl.HSet(hequeKeySchedules, s.Name, buf)
+	pl.HDel(runsKey, "next")
+	if _, err := pl.Exec(); err != nil {
+		c.logger.Error("failed to set schedule", zap.String("schedule", s.Name), zap.Error(err))
+		_ = pl.Discard()
+		return err
+	}
+	return nil
+}
+
+// DeleteSchedule deletes a schedule and the times of its runs.
+func (c *Client) DeleteSchedule(name string) error {
+	runsKey, err := c.keyFunc(hequeKeyScheduleRuns, name)
+	if err != nil {
+		return err
+	}
+
+	pl := c.redis.TxPipeline()
+	pl.HDel(hequeKeySchedules, name)
+	pl.Del(runsKey)
+	if _, err := pl.Exec(); err != nil {
+		c.logger.Error("failed to delete schedule", zap.String("schedule", name), zap.Error(err))
+		_ = pl.Discard()
+		return err
+	}
+	return nil
+}
+
+// Schedules lists the schedules, with the times of their last and next
+// runs.
+func (c *Client) Schedules() ([]*Schedule, error) {
+	defs, err := c.redis.HGetAll(hequeKeySchedules).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]*Schedule, 0, len(defs))
+	for name, def := range defs {
+		var s Schedule
+		if err := json.Unmarshal([]byte(def), &s); err != nil {
+			return nil, err
+		}
+		s.Name = name
+
+		runsKey, err := c.keyFunc(hequeKeyScheduleRuns, name)
+		if err != nil {
+			return nil, err
+		}
+
+		runs, err := c.redis.HGetAll(runsKey).Result()
+		if err != nil && err != redis.Nil {
+			return nil, err
+		}
+		if v, ok := runs["last"]; ok {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, err
+			}
+			s.LastRun = &t
+		}
+		if v, ok := runs["next"]; ok {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, err
+			}
+			s.NextRun = &t
+		}
+
+		schedules = append(schedules, &s)
+	}
+	return schedules, nil
+}
+
+// RecordScheduleRun records the times of the last and next runs of a
+// schedule. A zero last time leaves the last run unchanged.
+func (c *Client) RecordScheduleRun(name string, last time.Time, next time.Time) error {
+	runsKey, err := c.keyFunc(hequeKeyScheduleRuns, name)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"next": next.Format(time.RFC3339),
+	}
+	if !last.IsZero() {
+		fields["last"] = last.Format(time.RFC3339)
+	}
+
+	if err := c.redis.HMSet(runsKey, fields).Err(); err != nil {
+		c.logger.Error("failed to record schedule run", zap.String("schedule", name), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// FireSchedule enqueues spec for the run of a schedule due at due, and
+// records due as the last run of the schedule and next as the next one. The
+// run is claimed in the same transaction: if the next run of the schedule is
+// no longer due, i.e. the run was fired already, or if owner does not hold
+// the named lock, nothing is enqueued and ErrScheduleRunClaimed is returned.
+func (c *Client) FireSchedule(name string, due time.Time, next time.Time, lock string, owner string, spec JobSpec) (*Job, error) {
+	runsKey, err := c.keyFunc(hequeKeyScheduleRuns, name)
+	if err != nil {
+		return nil, err
+	}
+	lockKey, err := c.keyFunc(hequeKeyLocks, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	var job *Job
+	var wait *redis.Cmd
+	err = c.redis.Watch(func(tx *redis.Tx) error {
+		holder, err := tx.Get(lockKey).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		scheduled, err := tx.HGet(runsKey, "next").Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if holder != owner || scheduled != due.Format(time.RFC3339) {
+			return ErrScheduleRunClaimed
+		}
+
+		_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			if job, wait, err = c.enqueue(pipe, spec); err != nil {
+				return err
+			}
+			pipe.HMSet(runsKey, map[string]interface{}{
+				"last": due.Format(time.RFC3339),
+				"next": next.Format(time.RFC3339),
+			})
+			return nil
+		})
+		return err
+	}, lockKey, runsKey)
+	if err == redis.TxFailedErr {
+		// the lock or the run changed meanwhile
+		return nil, ErrScheduleRunClaimed
+	}
+	if err != nil {
+		if err != ErrScheduleRunClaimed {
+			c.logger.Error("failed to fire schedule", zap.String("schedule", name), zap.Error(err))
+		}
+		return nil, err
+	}
+
+	if err := c.enqueued(job, wait); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
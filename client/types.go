@@ -10,14 +10,50 @@ type Job struct {
 
 type JobStatus struct {
 	Phase          JobPhase
+	EnqueueTime    *time.Time
 	StartTime      *time.Time
 	CompletionTime *time.Time
+	// Attempts is the number of times the job has been dequeued.
+	Attempts int
+	// Result is the output of the job, set by its handler and stored by
+	// MarkAsDone for the callers of GetResult and WaitResult.
+	Result string
+	// Progress is how far the job has got, in percent, as last reported by
+	// its handler with ReportProgress.
+	Progress int
+	// ProgressMessage describes the current step of the job.
+	ProgressMessage string
 }
 
 type JobSpec struct {
 	Payload   string
 	QueueName string
 	Batch     string
+	// Timeout bounds the execution of the job. Zero means the default
+	// timeout of the worker applies.
+	Timeout time.Duration
+	// MaxRetries is the number of times a failed job is requeued before it
+	// is marked as failed.
+	MaxRetries int
+	// TraceContext is the trace context of the caller of EnqueueContext,
+	// propagated to the worker.
+	TraceContext map[string]string
+	// ResultTTL is how long the result of the job is kept once it is
+	// finished. Zero means DefaultResultTTL.
+	ResultTTL time.Duration
+	// DependsOn holds the IDs of the jobs which must succeed before the job
+	// is queued. Until then the job is waiting; it is cancelled if one of
+	// them fails or is cancelled.
+	DependsOn []string
+	// LockKey, if set, ensures that at most one job with this key runs at a
+	// time. The other jobs are parked when dequeued, and queued again one
+	// by one as the lock is released.
+	LockKey string
+	// OnSuccess and OnFailure are the follow-up jobs enqueued by MarkAsDone
+	// and MarkAsFailed respectively, in the same transaction. A follow-up
+	// without batch or trace context inherits those of the job.
+	OnSuccess *JobSpec
+	OnFailure *JobSpec
 }
 
 // JobPhase is a label for the condition of a job at the current time.
@@ -27,6 +63,9 @@ type JobPhase string
 const (
 	// JobPending means the job has been accepted by the system, but the command has not been started.
 	JobPending JobPhase = "pending"
+	// JobWaiting means the job has been accepted by the system, but waits for the success of the jobs
+	// it depends on before it is queued.
+	JobWaiting JobPhase = "waiting"
 	// JobRunning means the job has been bound to a worker and the command have been started.
 	JobRunning JobPhase = "running"
 	// JobSucceeded means that the command have voluntarily terminated with exit code of 0.
@@ -34,12 +73,100 @@ const (
 	// JobFailed means that the command have terminated, and was terminated in a failure (exited with
 	// a non-zero exit code or was stopped by the system).
 	JobFailed JobPhase = "failed"
+	// JobCancelled means that the job was cancelled before it started.
+	JobCancelled JobPhase = "cancelled"
 )
 
+// JobResult is the outcome of a finished job.
+type JobResult struct {
+	// Phase is JobSucceeded, JobFailed or JobCancelled.
+	Phase JobPhase `json:"phase"`
+	// QueueName is the queue of the job.
+	QueueName string `json:"queue,omitempty"`
+	// Result is the output of a succeeded job, if any.
+	Result         string    `json:"result,omitempty"`
+	CompletionTime time.Time `json:"completionTime"`
+}
+
 // redis 任务计数器
 type BatchCount struct {
-	Pending *string `json:"pending"`
-	Running *string `json:"running"`
-	Done    *string `json:"done"`
-	Failed  *string `json:"failed"`
+	Pending   *string `json:"pending"`
+	Running   *string `json:"running"`
+	Done      *string `json:"done"`
+	Failed    *string `json:"failed"`
+	Cancelled *string `json:"cancelled"`
+}
+
+// BatchStatus holds the counters of a batch. The jobs waiting for their
+// dependencies are counted as pending.
+type BatchStatus struct {
+	Pending   int `json:"pending"`
+	Running   int `json:"running"`
+	Done      int `json:"done"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+}
+
+// Progress returns the share of the jobs of the batch which are done, failed
+// or cancelled. An empty batch is complete.
+func (s *BatchStatus) Progress() float64 {
+	// 计算进度
+	total := s.Done + s.Pending + s.Running + s.Failed + s.Cancelled
+	if total == 0 {
+		return 1
+	}
+	return float64(s.Done+s.Failed+s.Cancelled) / float64(total)
+}
+
+// RateLimit limits how fast the jobs of a queue are dequeued, across all the
+// workers consuming the queue.
+type RateLimit struct {
+	// Rate is the number of jobs per second.
+	Rate float64
+	// Burst is the number of jobs that can be dequeued at once.
+	Burst int
+}
+
+// Schedule enqueues a job periodically, see the heque scheduler.
+type Schedule struct {
+	Name string `json:"name"`
+	// Cron is a standard cron expression, e.g. "0 2 * * *" for every night
+	// at 2am, or a descriptor such as "@weekly".
+	Cron       string `json:"cron"`
+	QueueName  string `json:"queueName"`
+	Payload    string `json:"payload"`
+	MaxRetries int    `json:"maxRetries,omitempty"`
+	// Batch prefixes the batch of the job of every run, which is completed
+	// with the time of the run. It defaults to the name of the schedule.
+	Batch string `json:"batch,omitempty"`
+
+	// LastRun and NextRun are recorded by the scheduler.
+	LastRun *time.Time `json:"-"`
+	NextRun *time.Time `json:"-"`
+}
+
+// QueueStatus holds the number of jobs of a queue by state, and the number of
+// its consumers.
+type QueueStatus struct {
+	Name    string `json:"name"`
+	Pending int    `json:"pending"`
+	Running int    `json:"running"`
+	// Failed is the number of failed jobs whose result has not expired.
+	Failed int `json:"failed"`
+	// Consumers is the number of live workers of the queue, counting every
+	// job held under a lease over HTTP as a worker.
+	Consumers int `json:"consumers"`
+}
+
+// WorkerInfo describes a worker process registered in redis.
+type WorkerInfo struct {
+	ID        string
+	Hostname  string
+	PID       int
+	Queues    []string
+	Version   string
+	StartTime time.Time
+	Heartbeat time.Time
+	// Jobs maps the IDs of the jobs held by the worker to their queue.
+	Jobs map[string]string
 }
@@ -0,0 +1,454 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"go.uber.org/zap"
+)
+
+// requeueScript moves a job from the running list back to the head of the
+// pending list, only if it is still running. KEYS: running, pending and,
+// optionally, the batch counter. ARGV: job id.
+var requeueScript = redis.NewScript(`
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call("RPUSH", KEYS[2], ARGV[1])
+if KEYS[3] then
+	redis.call("HINCRBY", KEYS[3], "running", -1)
+	redis.call("HINCRBY", KEYS[3], "pending", 1)
+end
+return 1
+`)
+
+// Heartbeat registers the worker, or refreshes its registration, for ttl.
+// The current jobs of the worker are replaced by w.Jobs, and the locks they
+// hold are extended for ttl.
+func (c *Client) Heartbeat(w *WorkerInfo, ttl time.Duration) error {
+	workerKey, err := c.keyFunc(hequeKeyWorkers, w.ID)
+	if err != nil {
+		return err
+	}
+
+	workerJobsKey, err := c.keyFunc(hequeKeyWorkerJobs, w.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	// ****************
+	// redis事务开始
+	// ****************
+	pl := c.redis.TxPipeline()
+	pl.HMSet(workerKey, map[string]interface{}{
+		"hostname":  w.Hostname,
+		"pid":       w.PID,
+		"queues":    strings.Join(w.Queues, ","),
+		"version":   w.Version,
+		"started":   w.StartTime.Format(time.RFC3339),
+		"heartbeat": now.Format(time.RFC3339),
+	})
+	pl.Expire(workerKey, ttl)
+	pl.Del(workerJobsKey)
+	if len(w.Jobs) > 0 {
+		jobs := make(map[string]interface{}, len(w.Jobs))
+		for id, queueName := range w.Jobs {
+			jobs[id] = queueName
+		}
+		pl.HMSet(workerJobsKey, jobs)
+
+		// the locks of the jobs are held as long as the worker is alive
+		ids := make([]string, 0, len(w.Jobs))
+		for id := range w.Jobs {
+			ids = append(ids, id)
+		}
+		if err := c.extendJobLocks(pl, ids, ttl); err != nil {
+			c.logger.Error("failed to heartbeat", zap.String("worker", w.ID), zap.Error(err))
+			_ = pl.Discard()
+			return err
+		}
+	}
+	pl.ZAdd(hequeKeyWorkerIndex, &redis.Z{Score: float64(now.Unix()), Member: w.ID})
+
+	// ****************
+	// redis事务结束
+	// ****************
+	if _, err := pl.Exec(); err != nil {
+		c.logger.Error("failed to heartbeat", zap.String("worker", w.ID), zap.Error(err))
+		_ = pl.Discard()
+		return err
+	}
+	w.Heartbeat = now
+	return nil
+}
+
+// Unregister removes the worker from the registry. It is called by a worker
+// on clean shutdown, after its jobs have been acknowledged.
+func (c *Client) Unregister(workerID string) error {
+	workerKey, err := c.keyFunc(hequeKeyWorkers, workerID)
+	if err != nil {
+		return err
+	}
+
+	workerJobsKey, err := c.keyFunc(hequeKeyWorkerJobs, workerID)
+	if err != nil {
+		return err
+	}
+
+	pl := c.redis.TxPipeline()
+	pl.Del(workerKey, workerJobsKey)
+	pl.ZRem(hequeKeyWorkerIndex, workerID)
+	if _, err := pl.Exec(); err != nil {
+		c.logger.Error("failed to unregister worker", zap.String("worker", workerID), zap.Error(err))
+		_ = pl.Discard()
+		return err
+	}
+	return nil
+}
+
+// Workers lists the live workers, i.e. those whose heartbeat has not expired.
+func (c *Client) Workers() ([]*WorkerInfo, error) {
+	ids, err := c.redis.ZRange(hequeKeyWorkerIndex, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var workers []*WorkerInfo
+	for _, id := range ids {
+		w, err := c.getWorker(id)
+		if err != nil {
+			return nil, err
+		}
+		if w != nil {
+			workers = append(workers, w)
+		}
+	}
+	return workers, nil
+}
+
+// orphanGracePeriod is how long a running job may be owned by no worker
+// before it is requeued, see reapOrphans. A job is moved to the running list
+// when it is dequeued, but only owned once its consumer heartbeats.
+const orphanGracePeriod = time.Minute
+
+// ReapDeadWorkers requeues the jobs held by workers whose heartbeat has
+// expired and removes those workers from the registry. It also requeues the
+// running jobs owned by no worker, see reapOrphans. It returns the number of
+// jobs requeued.
+func (c *Client) ReapDeadWorkers() (int, error) {
+	ids, err := c.redis.ZRange(hequeKeyWorkerIndex, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var requeued int
+	for _, id := range ids {
+		workerKey, err := c.keyFunc(hequeKeyWorkers, id)
+		if err != nil {
+			return requeued, err
+		}
+
+		alive, err := c.redis.Exists(workerKey).Result()
+		if err != nil {
+			return requeued, err
+		}
+		if alive > 0 {
+			continue
+		}
+
+		workerJobsKey, err := c.keyFunc(hequeKeyWorkerJobs, id)
+		if err != nil {
+			return requeued, err
+		}
+
+		jobs, err := c.redis.HGetAll(workerJobsKey).Result()
+		if err != nil {
+			return requeued, err
+		}
+
+		for jobID, queueName := range jobs {
+			ok, err := c.requeue(queueName, jobID, 0)
+			if err != nil {
+				return requeued, err
+			}
+			if ok {
+				c.logger.Info("requeued job of dead worker", zap.String("job", jobID), zap.String("queue", queueName), zap.String("worker", id))
+				requeued++
+			}
+		}
+
+		if err := c.Unregister(id); err != nil {
+			return requeued, err
+		}
+	}
+
+	n, err := c.reapOrphans()
+	return requeued + n, err
+}
+
+// reapOrphans requeues the running jobs which no registered worker has
+// owned for orphanGracePeriod, e.g. because their consumer died between
+// dequeuing them and its next heartbeat. The jobs found without an owner are
+// recorded with their attempt and the time they were first found, so that a
+// job dequeued again meanwhile is not requeued.
+func (c *Client) reapOrphans() (int, error) {
+	ids, err := c.redis.ZRange(hequeKeyWorkerIndex, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	owned := make(map[string]bool)
+	for _, id := range ids {
+		workerJobsKey, err := c.keyFunc(hequeKeyWorkerJobs, id)
+		if err != nil {
+			return 0, err
+		}
+		jobs, err := c.redis.HKeys(workerJobsKey).Result()
+		if err != nil {
+			return 0, err
+		}
+		for _, jobID := range jobs {
+			owned[jobID] = true
+		}
+	}
+
+	suspects, err := c.redis.HGetAll(hequeKeyOrphans).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	queueNames, err := c.scanNames(hequeKeyRunning)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var requeued int
+	orphans := make(map[string]bool)
+	for _, queueName := range queueNames {
+		runningKey, err := c.keyFunc(hequeKeyRunning, queueName)
+		if err != nil {
+			return requeued, err
+		}
+		running, err := c.redis.LRange(runningKey, 0, -1).Result()
+		if err != nil {
+			return requeued, err
+		}
+
+		for _, jobID := range running {
+			if owned[jobID] {
+				continue
+			}
+			specKey, err := c.keyFunc(hequeKeySpecs, jobID)
+			if err != nil {
+				return requeued, err
+			}
+			// jobs enqueued without a stored spec have no attempts
+			attempts, err := c.redis.HGet(specKey, "attempts").Int()
+			if err != nil && err != redis.Nil {
+				return requeued, err
+			}
+
+			// the suspect is recorded as "<attempts>:<unix time>"
+			var seen int64
+			if parts := strings.SplitN(suspects[jobID], ":", 2); len(parts) == 2 && parts[0] == strconv.Itoa(attempts) {
+				seen, _ = strconv.ParseInt(parts[1], 10, 64)
+			}
+			if seen == 0 {
+				orphans[jobID] = true
+				if err := c.redis.HSet(hequeKeyOrphans, jobID, fmt.Sprintf("%d:%d", attempts, now.Unix())).Err(); err != nil {
+					return requeued, err
+				}
+				continue
+			}
+			if now.Sub(time.Unix(seen, 0)) < orphanGracePeriod {
+				orphans[jobID] = true
+				continue
+			}
+
+			ok, err := c.requeue(queueName, jobID, attempts)
+			if err != nil {
+				return requeued, err
+			}
+			if ok {
+				c.logger.Info("requeued job owned by no worker", zap.String("job", jobID), zap.String("queue", queueName))
+				requeued++
+			}
+		}
+	}
+
+	// the jobs which were requeued, finished or owned meanwhile are no
+	// longer suspects
+	var stale []string
+	for jobID := range suspects {
+		if !orphans[jobID] {
+			stale = append(stale, jobID)
+		}
+	}
+	if len(stale) > 0 {
+		if err := c.redis.HDel(hequeKeyOrphans, stale...).Err(); err != nil {
+			return requeued, err
+		}
+	}
+	return requeued, nil
+}
+
+// scanNames returns the names of the keys starting with prefix, without
+// the prefix.
+func (c *Client) scanNames(prefix string) ([]string, error) {
+	var names []string
+	iter := c.redis.Scan(0, prefix+"*", 100).Iterator()
+	for iter.Next() {
+		names = append(names, strings.TrimPrefix(iter.Val(), prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// requeue moves a running job back to the head of its pending queue. It
+// reports false if the job was no longer running or, unless attempts is
+// zero, was running another attempt.
+func (c *Client) requeue(queueName string, jobID string, attempts int) (bool, error) {
+	runningKey, err := c.keyFunc(hequeKeyRunning, queueName)
+	if err != nil {
+		return false, err
+	}
+
+	pendingKey, err := c.keyFunc(hequeKeyPending, queueName)
+	if err != nil {
+		return false, err
+	}
+
+	keys := []string{runningKey, pendingKey}
+
+	job, err := c.getJob(queueName, jobID)
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	if job != nil && job.Spec.Batch != "" {
+		batchKey, err := c.keyFunc(hequeKeyBatches, job.Spec.Batch)
+		if err != nil {
+			return false, err
+		}
+		keys = append(keys, batchKey)
+	}
+
+	var requeued *redis.Cmd
+	err = c.watch(func(tx *redis.Tx) error {
+		if attempts > 0 {
+			if err := c.checkRunning(tx, queueName, jobID, attempts); err != nil {
+				return err
+			}
+		}
+
+		pl := tx.TxPipeline()
+		// EVALSHA may not be used in a transaction, as the script might not
+		// be loaded
+		requeued = requeueScript.Eval(pl, keys, jobID)
+		if job != nil {
+			if err := c.unlockJob(tx, pl, job); err != nil {
+				return err
+			}
+		}
+		_, err := pl.Exec()
+		return err
+	})
+	if err == ErrJobNotRunning {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	n, _ := requeued.Int()
+	if n == 1 {
+		jobsRequeued.WithLabelValues(queueName).Inc()
+	}
+	return n == 1, nil
+}
+
+// putBack moves a job just dequeued by dequeue, and locked, back to the head
+// of its pending queue and releases its lock. Unlike requeue, it leaves the
+// counters of the batch, which were not updated yet.
+func (c *Client) putBack(queueName string, jobID string) error {
+	runningKey, err := c.keyFunc(hequeKeyRunning, queueName)
+	if err != nil {
+		return err
+	}
+
+	pendingKey, err := c.keyFunc(hequeKeyPending, queueName)
+	if err != nil {
+		return err
+	}
+
+	job, err := c.getJob(queueName, jobID)
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	return c.watch(func(tx *redis.Tx) error {
+		pl := tx.TxPipeline()
+		// EVALSHA may not be used in a transaction, as the script might not
+		// be loaded
+		requeueScript.Eval(pl, []string{runningKey, pendingKey}, jobID)
+		if job != nil {
+			if err := c.unlockJob(tx, pl, job); err != nil {
+				return err
+			}
+		}
+		_, err := pl.Exec()
+		return err
+	})
+}
+
+func (c *Client) getWorker(id string) (*WorkerInfo, error) {
+	workerKey, err := c.keyFunc(hequeKeyWorkers, id)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := c.redis.HGetAll(workerKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		// heartbeat expired
+		return nil, nil
+	}
+
+	workerJobsKey, err := c.keyFunc(hequeKeyWorkerJobs, id)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := c.redis.HGetAll(workerJobsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WorkerInfo{
+		ID:       id,
+		Hostname: fields["hostname"],
+		Version:  fields["version"],
+		Jobs:     jobs,
+	}
+	if fields["queues"] != "" {
+		w.Queues = strings.Split(fields["queues"], ",")
+	}
+	if w.PID, err = strconv.Atoi(fields["pid"]); err != nil {
+		return nil, err
+	}
+	if w.StartTime, err = time.Parse(time.RFC3339, fields["started"]); err != nil {
+		return nil, err
+	}
+	if w.Heartbeat, err = time.Parse(time.RFC3339, fields["heartbeat"]); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,461 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"go.uber.org/zap"
+)
+
+// waitScript makes a job wait for its dependencies, run in the transaction
+// of EnqueueContext instead of pushing the job to its pending queue. The job
+// is queued at once if all the dependencies have succeeded already, and is
+// cancelled if one of them has failed or was cancelled. It returns the new
+// state of the job, "queued", "waiting" or "cancelled", followed by the
+// number of dependencies left, or for a cancelled job the number of jobs of
+// the batch still pending or running. KEYS: pending, dependencies, job,
+// spec, batch, then the spec, result and dependents of every dependency.
+// ARGV: job id, the ids of the dependencies.
+var waitScript = redis.NewScript(`
+local waiting = 0
+for i = 2, #ARGV do
+	if redis.call("EXISTS", KEYS[3 * i]) == 1 then
+		redis.call("SADD", KEYS[3 * i + 2], ARGV[1])
+		redis.call("SADD", KEYS[2], ARGV[i])
+		waiting = waiting + 1
+	elseif redis.call("HGET", KEYS[3 * i + 1], "phase") ~= "done" then
+		for j = 2, i - 1 do
+			redis.call("SREM", KEYS[3 * j + 2], ARGV[1])
+		end
+		redis.call("DEL", KEYS[2], KEYS[3], KEYS[4])
+		local pending = redis.call("HINCRBY", KEYS[5], "pending", -1)
+		redis.call("HINCRBY", KEYS[5], "cancelled", 1)
+		return {"cancelled", pending + (tonumber(redis.call("HGET", KEYS[5], "running")) or 0)}
+	end
+end
+if waiting == 0 then
+	redis.call("LPUSH", KEYS[1], ARGV[1])
+	return {"queued", 0}
+end
+return {"waiting", waiting}
+`)
+
+// releaseScript removes a succeeded job from the dependencies of the jobs
+// waiting for it, and queues those with no dependency left. It returns the
+// ids of the queued jobs. KEYS: dependents, then the dependencies and the
+// pending queue of every job waiting for it. ARGV: job id, the ids of the
+// jobs waiting for it.
+var releaseScript = redis.NewScript(`
+local released = {}
+for i = 2, #ARGV do
+	local dependencies = KEYS[2 * i - 2]
+	if redis.call("SREM", dependencies, ARGV[1]) == 1 and redis.call("SCARD", dependencies) == 0 then
+		redis.call("LPUSH", KEYS[2 * i - 1], ARGV[i])
+		table.insert(released, ARGV[i])
+	end
+end
+redis.call("DEL", KEYS[1])
+return released
+`)
+
+// cancelDependentsLua defines cancelDependents, which cancels the jobs
+// waiting for a failed or cancelled job, and the jobs waiting for those in
+// turn, counting them as cancelled in their batches. It is shared by
+// cancelDependentsScript and cancelScript. From base, KEYS hold the
+// dependents of the job, followed by the dependencies, job, spec, batch and
+// dependents of every job to cancel, of which the ids follow the job id in
+// ARGV. It returns the number of jobs of the batch still pending or running
+// for every cancelled job, or -1 for a job which was not waiting any more.
+const cancelDependentsLua = `
+local function cancelDependents(base)
+	local cancelled = {}
+	for i = 2, #ARGV do
+		local k = base + 5 * i - 9
+		if redis.call("DEL", KEYS[k]) == 1 then
+			redis.call("DEL", KEYS[k + 1], KEYS[k + 2], KEYS[k + 4])
+			local pending = redis.call("HINCRBY", KEYS[k + 3], "pending", -1)
+			redis.call("HINCRBY", KEYS[k + 3], "cancelled", 1)
+			table.insert(cancelled, pending + (tonumber(redis.call("HGET", KEYS[k + 3], "running")) or 0))
+		else
+			table.insert(cancelled, -1)
+		end
+	end
+	redis.call("DEL", KEYS[base])
+	return cancelled
+end
+`
+
+// cancelDependentsScript cancels the jobs waiting for a failed job, see
+// cancelDependentsLua. KEYS and ARGV start with the dependents and the id of
+// the job.
+var cancelDependentsScript = redis.NewScript(cancelDependentsLua + `
+return cancelDependents(1)
+`)
+
+// checkDependencies returns ErrUnknownDependency if one of the jobs spec
+// depends on is neither enqueued nor has a result. The follow-ups of spec are
+// checked as well, as they are enqueued without a caller to report to.
+func (c *Client) checkDependencies(spec *JobSpec) error {
+	if spec == nil {
+		return nil
+	}
+	for _, followUp := range []*JobSpec{spec.OnSuccess, spec.OnFailure} {
+		if err := c.checkDependencies(followUp); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range spec.DependsOn {
+		specKey, err := c.keyFunc(hequeKeySpecs, id)
+		if err != nil {
+			return err
+		}
+		resultKey, err := c.keyFunc(hequeKeyResults, id)
+		if err != nil {
+			return err
+		}
+
+		n, err := c.redis.Exists(specKey, resultKey).Result()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUnknownDependency
+		}
+	}
+	return nil
+}
+
+// waitForDependencies adds to pl the queueing of job after its dependencies,
+// see waitScript. The outcome is read by waited once pl is executed.
+func (c *Client) waitForDependencies(pl redis.Pipeliner, job *Job) (*redis.Cmd, error) {
+	var keys []string
+	for _, k := range [][2]string{
+		{hequeKeyPending, job.Spec.QueueName},
+		{hequeKeyDependencies, job.ID},
+		{hequeKeyJobs, job.ID},
+		{hequeKeySpecs, job.ID},
+		{hequeKeyBatches, job.Spec.Batch},
+	} {
+		key, err := c.keyFunc(k[0], k[1])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	args := []interface{}{job.ID}
+	for _, id := range job.Spec.DependsOn {
+		for _, prefix := range []string{hequeKeySpecs, hequeKeyResults, hequeKeyDependents} {
+			key, err := c.keyFunc(prefix, id)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		args = append(args, id)
+	}
+	// EVALSHA may not be used in a transaction, as the script might not be
+	// loaded
+	return waitScript.Eval(pl, keys, args...), nil
+}
+
+// waited updates the phase of job from the outcome of waitForDependencies.
+func (c *Client) waited(job *Job, wait *redis.Cmd) error {
+	reply, ok := wait.Val().([]interface{})
+	if !ok || len(reply) != 2 {
+		return fmt.Errorf("heque_redis_client: unexpected reply %v", wait.Val())
+	}
+	state, _ := reply[0].(string)
+	n, _ := reply[1].(int64)
+
+	switch state {
+	case "waiting":
+		job.Status.Phase = JobWaiting
+	case "cancelled":
+		c.jobLogger(job).Info("dependency of job failed, cancelling it")
+		c.cancelled(job, int(n))
+	}
+	return nil
+}
+
+// dependents reads with tx the jobs waiting for job, and if transitive the
+// jobs waiting for those in turn. tx watches what it reads, so that its
+// transaction fails if a job starts waiting for one of them meanwhile.
+func (c *Client) dependents(tx *redis.Tx, job *Job, transitive bool) ([]*Job, error) {
+	var dependents []*Job
+	seen := map[string]bool{job.ID: true}
+	parents := []string{job.ID}
+	for len(parents) > 0 {
+		parent := parents[len(parents)-1]
+		parents = parents[:len(parents)-1]
+
+		dependentsKey, err := c.keyFunc(hequeKeyDependents, parent)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Watch(dependentsKey).Err(); err != nil {
+			return nil, err
+		}
+		ids, err := tx.SMembers(dependentsKey).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			dependenciesKey, err := c.keyFunc(hequeKeyDependencies, id)
+			if err != nil {
+				return nil, err
+			}
+			specKey, err := c.keyFunc(hequeKeySpecs, id)
+			if err != nil {
+				return nil, err
+			}
+			if err := tx.Watch(dependenciesKey, specKey).Err(); err != nil {
+				return nil, err
+			}
+			waiting, err := tx.Exists(dependenciesKey).Result()
+			if err != nil {
+				return nil, err
+			}
+			fields, err := tx.HMGet(specKey, "queue", "batch", "resultTTL").Result()
+			if err != nil {
+				return nil, err
+			}
+			queue, _ := fields[0].(string)
+			if waiting == 0 || queue == "" {
+				// cancelled already, because another dependency failed
+				continue
+			}
+
+			dependent := &Job{ID: id}
+			dependent.Spec.QueueName = queue
+			dependent.Spec.Batch, _ = fields[1].(string)
+			if ttl, ok := fields[2].(string); ok {
+				// left zero, i.e. DefaultResultTTL, if invalid
+				dependent.Spec.ResultTTL, _ = time.ParseDuration(ttl)
+			}
+			dependents = append(dependents, dependent)
+			if transitive {
+				parents = append(parents, id)
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// releaseDependents adds to pl the queueing of the jobs waiting for job,
+// which has succeeded, that have no other dependency left, see
+// releaseScript. The jobs are read with tx, see dependents. The outcome is
+// read by released once pl is executed.
+func (c *Client) releaseDependents(tx *redis.Tx, pl redis.Pipeliner, job *Job) (*redis.Cmd, error) {
+	dependents, err := c.dependents(tx, job, false)
+	if err != nil {
+		return nil, err
+	}
+
+	dependentsKey, err := c.keyFunc(hequeKeyDependents, job.ID)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{dependentsKey}
+	args := []interface{}{job.ID}
+	for _, dependent := range dependents {
+		dependenciesKey, err := c.keyFunc(hequeKeyDependencies, dependent.ID)
+		if err != nil {
+			return nil, err
+		}
+		pendingKey, err := c.keyFunc(hequeKeyPending, dependent.Spec.QueueName)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, dependenciesKey, pendingKey)
+		args = append(args, dependent.ID)
+	}
+	// EVALSHA may not be used in a transaction, as the script might not be
+	// loaded
+	return releaseScript.Eval(pl, keys, args...), nil
+}
+
+// released logs the jobs queued by releaseDependents.
+func (c *Client) released(job *Job, release *redis.Cmd) {
+	if ids, ok := release.Val().([]interface{}); ok && len(ids) > 0 {
+		c.jobLogger(job).Debug("released dependents of job", zap.Any("dependents", ids))
+	}
+}
+
+// cancelDependentsKeys returns the jobs to cancel with job, which has failed
+// or is cancelled, read with tx, see dependents, and the KEYS and ARGV of
+// cancelDependentsLua.
+func (c *Client) cancelDependentsKeys(tx *redis.Tx, job *Job) ([]*Job, []string, []interface{}, error) {
+	dependents, err := c.dependents(tx, job, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	dependentsKey, err := c.keyFunc(hequeKeyDependents, job.ID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keys := []string{dependentsKey}
+	args := []interface{}{job.ID}
+	for _, dependent := range dependents {
+		for _, k := range [][2]string{
+			{hequeKeyDependencies, dependent.ID},
+			{hequeKeyJobs, dependent.ID},
+			{hequeKeySpecs, dependent.ID},
+			{hequeKeyBatches, dependent.Spec.Batch},
+			{hequeKeyDependents, dependent.ID},
+		} {
+			key, err := c.keyFunc(k[0], k[1])
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			keys = append(keys, key)
+		}
+		args = append(args, dependent.ID)
+	}
+	return dependents, keys, args, nil
+}
+
+// cancelDependents adds to pl the cancellation of the jobs waiting for job,
+// which has failed, and of the jobs waiting for those in turn, see
+// cancelDependentsScript. The outcome is read by cancelledDependents once
+// pl is executed.
+func (c *Client) cancelDependents(tx *redis.Tx, pl redis.Pipeliner, job *Job) ([]*Job, *redis.Cmd, error) {
+	dependents, keys, args, err := c.cancelDependentsKeys(tx, job)
+	if err != nil {
+		return nil, nil, err
+	}
+	// EVALSHA may not be used in a transaction, as the script might not be
+	// loaded
+	return dependents, cancelDependentsScript.Eval(pl, keys, args...), nil
+}
+
+// cancelledDependents records the cancellation of the dependents of job,
+// given the number of jobs of their batches still pending or running
+// returned by cancelDependentsLua.
+func (c *Client) cancelledDependents(job *Job, dependents []*Job, remaining []interface{}) {
+	for i, dependent := range dependents {
+		if i >= len(remaining) {
+			break
+		}
+		n, _ := remaining[i].(int64)
+		if n < 0 {
+			continue
+		}
+		c.jobLogger(dependent).Info("dependency of job failed, cancelling it", zap.String("dependency", job.ID))
+		c.cancelled(dependent, int(n))
+	}
+}
+
+// Workflow is a DAG of jobs enqueued together, each step running after the
+// success of the steps it depends on. For instance, the valuation of a house
+// followed by lookups run in parallel, and then a report combining them:
+//
+//	var w client.Workflow
+//	w.Add("valuation", valuation)
+//	w.Add("community", community, "valuation")
+//	w.Add("rating", rating, "valuation")
+//	w.Add("trend", trend, "valuation")
+//	w.Add("report", report, "community", "rating", "trend")
+//	jobs, err := cli.EnqueueWorkflow(ctx, &w)
+//
+// If a step fails, the steps depending on it are cancelled.
+type Workflow struct {
+	steps []workflowStep
+}
+
+type workflowStep struct {
+	name  string
+	spec  JobSpec
+	after []string
+}
+
+// Add adds a step to the workflow, which runs after the success of the
+// steps named after. These must have been added before.
+func (w *Workflow) Add(name string, spec JobSpec, after ...string) {
+	w.steps = append(w.steps, workflowStep{name: name, spec: spec, after: after})
+}
+
+// Validate checks the steps of the workflow and return an error if it fails
+func (w *Workflow) Validate() error {
+	names := make(map[string]bool, len(w.steps))
+	for _, step := range w.steps {
+		if step.name == "" {
+			return fmt.Errorf("workflow step without name")
+		}
+		if names[step.name] {
+			return fmt.Errorf("workflow step %s: duplicate name", step.name)
+		}
+		for _, name := range step.after {
+			if !names[name] {
+				return fmt.Errorf("workflow step %s: runs after unknown step %s", step.name, name)
+			}
+		}
+		names[step.name] = true
+	}
+	return nil
+}
+
+// EnqueueWorkflow enqueues the jobs of the steps of w in the order they were
+// added, and returns them by step name. On error, the jobs already enqueued
+// are returned as well, so that the caller can cancel them.
+func (c *Client) EnqueueWorkflow(ctx context.Context, w *Workflow) (map[string]*Job, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+
+	jobs := make(map[string]*Job, len(w.steps))
+	for _, step := range w.steps {
+		spec := step.spec
+		spec.DependsOn = append([]string(nil), spec.DependsOn...)
+		for _, name := range step.after {
+			spec.DependsOn = append(spec.DependsOn, jobs[name].ID)
+		}
+
+		job, err := c.EnqueueContext(ctx, spec)
+		if err != nil {
+			return jobs, err
+		}
+		jobs[step.name] = job
+	}
+	return jobs, nil
+}
+
+// enqueueFollowUp adds to pl the enqueueing of spec, a follow-up of job,
+// which inherits the batch and the trace context of job unless it has its
+// own. It does nothing if spec is nil.
+func (c *Client) enqueueFollowUp(pl redis.Pipeliner, job *Job, spec *JobSpec) (*Job, *redis.Cmd, error) {
+	if spec == nil {
+		return nil, nil, nil
+	}
+
+	followUp := *spec
+	if followUp.Batch == "" {
+		followUp.Batch = job.Spec.Batch
+	}
+	if followUp.TraceContext == nil {
+		followUp.TraceContext = job.Spec.TraceContext
+	}
+	return c.enqueue(pl, followUp)
+}
+
+// followedUp completes the enqueueing of the follow-up of job, once the
+// pipeline built by enqueueFollowUp is executed.
+func (c *Client) followedUp(job *Job, followUp *Job, wait *redis.Cmd) {
+	if err := c.enqueued(followUp, wait); err != nil {
+		c.jobLogger(job).Error("failed to enqueue follow-up of job", zap.Error(err))
+		return
+	}
+	c.jobLogger(job).Info("enqueued follow-up of job",
+		zap.String("followUp", followUp.ID),
+		zap.String("followUpQueue", followUp.Spec.QueueName))
+}
@@ -1,23 +1,59 @@
 package app
 
 import (
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/spf13/pflag"
+
+	"denggotech.cn/heque/heque/apiserver"
+	"denggotech.cn/heque/heque/apiserver/auth"
+	"denggotech.cn/heque/heque/apiserver/handlers"
+	"denggotech.cn/heque/heque/util/logging"
 )
 
 // ServerRunOptions runs a heque api server.
 type ServerRunOptions struct {
-	BindAddress  net.IP
-	BindPort     uint
-	ETCDServers  []string
-	Prefix       string
-	RedisAddress string
+	BindAddress       net.IP
+	BindPort          uint
+	StorageBackend    string
+	ETCDServers       []string
+	Prefix            string
+	RedisAddress      string
+	MaxDequeueWaiters int
+	MaxDequeueWait    time.Duration
+	MaxBatchWatchers  int
+	LeaseTTL          time.Duration
+
+	EnableCompression   bool
+	EnableCORS          bool
+	EnablePanicRecovery bool
+	EnableRequestID     bool
+	EnableAccessLog     bool
+	MaxRequestBodyBytes int64
+	RequestTimeout      time.Duration
+	ShutdownTimeout     time.Duration
+
+	TLSCertFile       string
+	TLSPrivateKeyFile string
+	ClientCAFile      string
+
+	TokenAuthFile           string
+	HMACAuthFile            string
+	JWTJWKSFile             string
+	JWTIssuer               string
+	JWTAudience             string
+	AuthorizationPolicyFile string
+
+	Logging *logging.Options
 }
 
 // NewServerRunOptions creates a new ServerRunOptions object with default parameters
 func NewServerRunOptions() *ServerRunOptions {
-	s := ServerRunOptions{}
+	s := ServerRunOptions{
+		Logging: logging.NewOptions(),
+	}
 	return &s
 }
 
@@ -28,15 +64,157 @@ func (s *ServerRunOptions) AddFlags(fs *pflag.FlagSet) {
 		"will be used (0.0.0.0 for all IPv4 interfaces and :: for all IPv6 interfaces).")
 	fs.UintVar(&s.BindPort, "bind-port", 8086, ""+
 		"The port on which to serve requests.")
+	fs.StringVar(&s.StorageBackend, "storage-backend", apiserver.StorageBackendRedis, ""+
+		"The storage backend of the jobs, redis (the queues shared with the workers) or etcd.")
 	fs.StringSliceVar(&s.ETCDServers, "etcd-servers", []string{"localhost:2379"}, ""+
 		"List of etcd servers to connect with (ip:port), comma separated.")
 	fs.StringVar(&s.Prefix, "prefix", "/registry", ""+
 		"The prefix of queue.")
 	fs.StringVar(&s.RedisAddress, "redis-address", "localhost:6379", ""+
-		"The address of redis server.")
+		"The address of redis server, used by the redis storage backend.")
+	fs.IntVar(&s.MaxDequeueWaiters, "max-dequeue-waiters", handlers.DefaultMaxWaiters, ""+
+		"The number of dequeue requests waiting for a job at the same time, see the wait "+
+		"query parameter. Further requests are rejected with 429. With the redis storage "+
+		"backend, every waiting request holds a connection of the redis client.")
+	fs.DurationVar(&s.MaxDequeueWait, "max-dequeue-wait", handlers.DefaultMaxWait, ""+
+		"The longest wait of a dequeue request, longer waits are shortened.")
+	fs.IntVar(&s.MaxBatchWatchers, "max-batch-watchers", handlers.DefaultMaxWatchers, ""+
+		"The number of batch streams open at the same time. Further requests are rejected "+
+		"with 429. Every stream holds a connection to redis.")
+	fs.DurationVar(&s.LeaseTTL, "lease-ttl", handlers.DefaultLeaseTTL, ""+
+		"How long a job dequeued over HTTP is held by its consumer without a heartbeat, "+
+		"before it is requeued.")
+	fs.BoolVar(&s.EnableCompression, "enable-compression", true, ""+
+		"Compress the responses with gzip or deflate, as accepted by the client.")
+	fs.BoolVar(&s.EnableCORS, "enable-cors", false, ""+
+		"Allow cross-origin requests from any origin.")
+	fs.BoolVar(&s.EnablePanicRecovery, "enable-panic-recovery", true, ""+
+		"Reply 500 to the requests whose handler panics, instead of dropping the connection.")
+	fs.BoolVar(&s.EnableRequestID, "enable-request-id", true, ""+
+		"Give every request an ID, from its X-Request-ID header or else generated, "+
+		"echoed in the response and logged.")
+	fs.BoolVar(&s.EnableAccessLog, "enable-access-log", true, ""+
+		"Log every request with its status and duration.")
+	fs.Int64Var(&s.MaxRequestBodyBytes, "max-request-body-bytes", 1<<20, ""+
+		"The largest request body, in bytes. Zero means no limit.")
+	fs.DurationVar(&s.RequestTimeout, "request-timeout", time.Minute, ""+
+		"The timeout of the requests, except the streams and the dequeue requests waiting "+
+		"for a job. Zero means no timeout.")
+	fs.DurationVar(&s.ShutdownTimeout, "shutdown-timeout", 30*time.Second, ""+
+		"How long the requests in progress are waited for on shutdown, before their "+
+		"connections are closed.")
+	fs.StringVar(&s.TLSCertFile, "tls-cert-file", "", ""+
+		"If set, the PEM file of the certificate, followed by its intermediate CAs, "+
+		"with which to serve HTTPS instead of HTTP. It is reloaded when it changes.")
+	fs.StringVar(&s.TLSPrivateKeyFile, "tls-private-key-file", "", ""+
+		"The PEM file of the private key of --tls-cert-file. It is reloaded when it changes.")
+	fs.StringVar(&s.ClientCAFile, "client-ca-file", "", ""+
+		"If set, the PEM file of the CAs verifying the client certificates, which "+
+		"authenticate the requests, with the common name of the certificate as the user "+
+		"and its organizations as the groups. It is reloaded when it changes.")
+	fs.StringVar(&s.TokenAuthFile, "token-auth-file", "", ""+
+		"If set, the CSV file of the bearer tokens authenticating the requests, with a "+
+		"line per token: token,user,group1,group2,...")
+	fs.StringVar(&s.HMACAuthFile, "hmac-auth-file", "", ""+
+		"If set, the CSV file of the keys authenticating the requests signed with "+
+		"HMAC-SHA256, with a line per key: key ID,secret,user,group1,group2,...")
+	fs.StringVar(&s.JWTJWKSFile, "jwt-jwks-file", "", ""+
+		"If set, the JSON Web Key Set file of the keys verifying the JSON Web Tokens "+
+		"authenticating the requests as bearer tokens, signed with RS256 or ES256.")
+	fs.StringVar(&s.JWTIssuer, "jwt-issuer", "", ""+
+		"If set, the issuer (iss claim) the JSON Web Tokens must have.")
+	fs.StringVar(&s.JWTAudience, "jwt-audience", "", ""+
+		"If set, the audience (aud claim) the JSON Web Tokens must have.")
+	fs.StringVar(&s.AuthorizationPolicyFile, "authorization-policy-file", "", ""+
+		"If set, the YAML file of the policy allowing users and groups to use verbs on "+
+		"queues. Otherwise every authenticated request is allowed.")
+	s.Logging.AddFlags(fs)
 }
 
 // Validate checks ServerRunOptions and return an error if it fails
 func (s *ServerRunOptions) Validate() error {
+	switch s.StorageBackend {
+	case apiserver.StorageBackendRedis, apiserver.StorageBackendETCD:
+	default:
+		return fmt.Errorf("--storage-backend must be %s or %s", apiserver.StorageBackendRedis, apiserver.StorageBackendETCD)
+	}
+	if s.MaxDequeueWaiters <= 0 {
+		return fmt.Errorf("--max-dequeue-waiters must be positive")
+	}
+	if s.MaxDequeueWait <= 0 {
+		return fmt.Errorf("--max-dequeue-wait must be positive")
+	}
+	if s.MaxBatchWatchers <= 0 {
+		return fmt.Errorf("--max-batch-watchers must be positive")
+	}
+	if s.LeaseTTL <= 0 {
+		return fmt.Errorf("--lease-ttl must be positive")
+	}
+	if s.MaxRequestBodyBytes < 0 {
+		return fmt.Errorf("--max-request-body-bytes must not be negative")
+	}
+	if s.RequestTimeout < 0 {
+		return fmt.Errorf("--request-timeout must not be negative")
+	}
+	if s.ShutdownTimeout < 0 {
+		return fmt.Errorf("--shutdown-timeout must not be negative")
+	}
+	if (s.TLSCertFile == "") != (s.TLSPrivateKeyFile == "") {
+		return fmt.Errorf("--tls-cert-file and --tls-private-key-file must be set together")
+	}
+	if s.ClientCAFile != "" && s.TLSCertFile == "" {
+		return fmt.Errorf("--client-ca-file requires --tls-cert-file")
+	}
+	if s.JWTJWKSFile == "" && (s.JWTIssuer != "" || s.JWTAudience != "") {
+		return fmt.Errorf("--jwt-issuer and --jwt-audience require --jwt-jwks-file")
+	}
+	if s.AuthorizationPolicyFile != "" && s.ClientCAFile == "" && s.TokenAuthFile == "" && s.HMACAuthFile == "" && s.JWTJWKSFile == "" {
+		return fmt.Errorf("--authorization-policy-file requires --client-ca-file, --token-auth-file, --hmac-auth-file or --jwt-jwks-file")
+	}
 	return nil
 }
+
+// Authentication returns the authenticator of the requests, or nil if no
+// authentication is configured.
+func (s *ServerRunOptions) Authentication() (auth.Authenticator, error) {
+	var union auth.Union
+	if s.ClientCAFile != "" {
+		union = append(union, auth.X509Authenticator{})
+	}
+	if s.TokenAuthFile != "" {
+		a, err := auth.NewTokenAuthenticatorFromFile(s.TokenAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		union = append(union, a)
+	}
+	if s.JWTJWKSFile != "" {
+		a, err := auth.NewJWTAuthenticatorFromFile(s.JWTJWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.Issuer = s.JWTIssuer
+		a.Audience = s.JWTAudience
+		union = append(union, a)
+	}
+	if s.HMACAuthFile != "" {
+		a, err := auth.NewHMACAuthenticatorFromFile(s.HMACAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		union = append(union, a)
+	}
+	if len(union) == 0 {
+		return nil, nil
+	}
+	return union, nil
+}
+
+// Authorization returns the authorizer of the requests, or nil if no policy
+// is configured.
+func (s *ServerRunOptions) Authorization() (auth.Authorizer, error) {
+	if s.AuthorizationPolicyFile == "" {
+		return nil, nil
+	}
+	return auth.LoadPolicyFile(s.AuthorizationPolicyFile)
+}
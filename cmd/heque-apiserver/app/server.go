@@ -2,14 +2,16 @@ package app
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 	"go.etcd.io/etcd/clientv3"
 
 	"denggotech.cn/heque/heque/apiserver"
+	"denggotech.cn/heque/heque/client"
 	utilflag "denggotech.cn/heque/heque/util/flag"
-	utilredis "denggotech.cn/heque/heque/util/redis"
+	"denggotech.cn/heque/heque/util/logging"
 )
 
 func NewAPIServerCommand() *cobra.Command {
@@ -22,6 +24,12 @@ for the api objects which include jobs, queues, and others. The API
 Server services REST operations and provides the frontend to the
 cluster's shared state through which all other components interact.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := logging.Init(s.Logging)
+			if err != nil {
+				return err
+			}
+			defer logger.Sync()
+
 			utilflag.PrintFlags(cmd.Flags())
 
 			if err := s.Validate(); err != nil {
@@ -37,26 +45,79 @@ cluster's shared state through which all other components interact.`,
 	return cmd
 }
 
-// Run runs the specified APIServer. This should never exit.
+// Run runs the specified APIServer until stopCh is closed, then shuts it
+// down and closes the clients of etcd and redis.
 func Run(s *ServerRunOptions, stopCh <-chan struct{}) error {
-	storage, err := clientv3.New(clientv3.Config{
-		Endpoints:   s.ETCDServers,
-		DialTimeout: 5 * time.Second,
-	})
+	var storage *clientv3.Client
+	if s.StorageBackend == apiserver.StorageBackendETCD {
+		var err error
+		storage, err = clientv3.New(clientv3.Config{
+			Endpoints:   s.ETCDServers,
+			DialTimeout: 5 * time.Second,
+		})
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+	}
+
+	authenticator, err := s.Authentication()
 	if err != nil {
 		return err
 	}
-	defer storage.Close()
-
-	// Initialize the redis
-	err = utilredis.Init(s.RedisAddress)
+	authorizer, err := s.Authorization()
 	if err != nil {
 		return err
 	}
 
+	// Initialize heque client
+	var cli *client.Client
+	if s.StorageBackend == apiserver.StorageBackendRedis {
+		cli, err = client.New(client.Config{
+			Endpoints: []string{s.RedisAddress},
+		})
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+	}
+
 	srv := apiserver.NewAPIServer(&apiserver.Config{
-		Storage: storage,
-		Prefix:  s.Prefix,
+		StorageBackend:    s.StorageBackend,
+		Storage:           storage,
+		Prefix:            s.Prefix,
+		Client:            cli,
+		MaxDequeueWaiters: s.MaxDequeueWaiters,
+		MaxDequeueWait:    s.MaxDequeueWait,
+		MaxBatchWatchers:  s.MaxBatchWatchers,
+		LeaseTTL:          s.LeaseTTL,
+
+		EnableCompression:   s.EnableCompression,
+		EnableCORS:          s.EnableCORS,
+		EnablePanicRecovery: s.EnablePanicRecovery,
+		EnableRequestID:     s.EnableRequestID,
+		EnableAccessLog:     s.EnableAccessLog,
+		MaxRequestBodyBytes: s.MaxRequestBodyBytes,
+		RequestTimeout:      s.RequestTimeout,
+		ShutdownTimeout:     s.ShutdownTimeout,
+		TLSCertFile:         s.TLSCertFile,
+		TLSPrivateKeyFile:   s.TLSPrivateKeyFile,
+		ClientCAFile:        s.ClientCAFile,
+		Authenticator:       authenticator,
+		Authorizer:          authorizer,
 	})
-	return srv.ListenAndServe(fmt.Sprintf("%s:%d", s.BindAddress, s.BindPort))
+
+	var wg sync.WaitGroup
+	reaperStopCh := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.ReapLeases(reaperStopCh)
+	}()
+
+	err = srv.Run(fmt.Sprintf("%s:%d", s.BindAddress, s.BindPort), stopCh)
+	// the clients are closed once the reaper is stopped as well
+	close(reaperStopCh)
+	wg.Wait()
+	return err
 }
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"denggotech.cn/heque/heque/scheduler"
+	"denggotech.cn/heque/heque/util/logging"
+)
+
+// SchedulerOptions runs a heque scheduler.
+type SchedulerOptions struct {
+	RedisAddress   string
+	SchedulesFile  string
+	Interval       time.Duration
+	LeaderLeaseTTL time.Duration
+	Logging        *logging.Options
+}
+
+// NewSchedulerOptions creates a new SchedulerOptions object with default parameters
+func NewSchedulerOptions() *SchedulerOptions {
+	s := SchedulerOptions{
+		Logging: logging.NewOptions(),
+	}
+	return &s
+}
+
+// AddFlags adds flags for a specific scheduler to the specified FlagSet
+func (s *SchedulerOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&s.RedisAddress, "redis-address", "localhost:6379", ""+
+		"The address of redis server.")
+	fs.StringVar(&s.SchedulesFile, "schedules-file", "", ""+
+		"A YAML file of schedules, created or updated in redis at start. "+
+		"If blank, only the schedules already in redis are run.")
+	fs.DurationVar(&s.Interval, "interval", scheduler.DefaultInterval, ""+
+		"How often the schedules are checked.")
+	fs.DurationVar(&s.LeaderLeaseTTL, "leader-lease-ttl", scheduler.DefaultLeaseTTL, ""+
+		"How long the leadership outlives the last check of the leader, among the replicas of the scheduler.")
+	s.Logging.AddFlags(fs)
+}
+
+// Validate checks SchedulerOptions and return an error if it fails
+func (s *SchedulerOptions) Validate() error {
+	return nil
+}
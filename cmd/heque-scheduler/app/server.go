@@ -0,0 +1,71 @@
+package app
+
+import (
+	"github.com/spf13/cobra"
+
+	"denggotech.cn/heque/heque/apiserver"
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/scheduler"
+	utilflag "denggotech.cn/heque/heque/util/flag"
+	"denggotech.cn/heque/heque/util/logging"
+)
+
+func NewSchedulerCommand() *cobra.Command {
+	s := NewSchedulerOptions()
+
+	cmd := &cobra.Command{
+		Use: "heque-scheduler",
+		Long: `The heque scheduler enqueues periodic jobs, such as the nightly
+revaluation of the collateral, at the times given by cron expressions.
+Several replicas can run: only the elected leader enqueues jobs.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := logging.Init(s.Logging)
+			if err != nil {
+				return err
+			}
+			defer logger.Sync()
+
+			utilflag.PrintFlags(cmd.Flags())
+
+			if err := s.Validate(); err != nil {
+				return err
+			}
+
+			return Run(s, apiserver.SetupSignalHandler())
+		},
+	}
+
+	s.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+// Run runs the specified scheduler. This should never exit.
+func Run(s *SchedulerOptions, stopCh <-chan struct{}) error {
+	// Initialize heque client
+	cli, err := client.New(client.Config{
+		Endpoints: []string{s.RedisAddress},
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.SchedulesFile != "" {
+		schedules, err := scheduler.LoadFile(s.SchedulesFile)
+		if err != nil {
+			return err
+		}
+		for _, sched := range schedules {
+			if err := cli.SetSchedule(sched); err != nil {
+				return err
+			}
+		}
+	}
+
+	sch := scheduler.NewScheduler(&scheduler.Config{
+		Client:   cli,
+		Interval: s.Interval,
+		LeaseTTL: s.LeaderLeaseTTL,
+	})
+	return sch.Run(stopCh)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"time"
+
+	"denggotech.cn/heque/heque/cmd/heque-scheduler/app"
+)
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+
+	command := app.NewSchedulerCommand()
+
+	if err := command.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
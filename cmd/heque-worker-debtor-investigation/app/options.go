@@ -2,8 +2,11 @@ package app
 
 import (
 	"net"
+	"time"
 
 	"github.com/spf13/pflag"
+
+	"denggotech.cn/heque/heque/util/logging"
 )
 
 // WorkerOptions runs a heque worker.
@@ -11,15 +14,23 @@ type WorkerOptions struct {
 	BindAddress          net.IP
 	BindPort             uint
 	QueueName            string
+	JobTimeout           time.Duration
+	RateLimit            float64
+	RateBurst            int
 	RedisAddress         string
+	TracingExporter      string
+	TracingEndpoint      string
 	DebtdbAddress        string
 	CreditGatewayAddress string
 	IsMock               string
+	Logging              *logging.Options
 }
 
 // NewWorkerOptions creates a new WorkerOptions object with default parameters
 func NewWorkerOptions() *WorkerOptions {
-	w := WorkerOptions{}
+	w := WorkerOptions{
+		Logging: logging.NewOptions(),
+	}
 	return &w
 }
 
@@ -28,15 +39,27 @@ func (w *WorkerOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.IPVar(&w.BindAddress, "bind-address", net.ParseIP("127.0.0.1"), ""+
 		"The IP address on which to listen for the --bind-port port.")
 	fs.UintVar(&w.BindPort, "bind-port", 8087, ""+
-		"The port on which to serve requests.")
+		"The port on which to serve the health, readiness, metrics and debug endpoints.")
 	fs.StringVar(&w.QueueName, "queue-name", "investigate_debtor", ""+
 		"The name of queue.")
+	fs.DurationVar(&w.JobTimeout, "job-timeout", 5*time.Minute, ""+
+		"The execution timeout of the jobs which do not specify their own. Zero means no timeout.")
+	fs.Float64Var(&w.RateLimit, "rate-limit", 0, ""+
+		"The maximum number of jobs per second dequeued from the queue by all its workers together. "+
+		"If zero, the limit already configured for the queue, if any, is kept.")
+	fs.IntVar(&w.RateBurst, "rate-burst", 1, ""+
+		"The number of jobs that can be dequeued at once under --rate-limit.")
 	fs.StringVar(&w.RedisAddress, "redis-address", "localhost:6379", ""+
 		"The address of redis server.")
+	fs.StringVar(&w.TracingExporter, "tracing-exporter", "", ""+
+		"The exporter of the OpenTelemetry spans: \"stdout\" or \"jaeger\". If blank, tracing is disabled.")
+	fs.StringVar(&w.TracingEndpoint, "tracing-endpoint", "http://localhost:14268/api/traces", ""+
+		"The endpoint of the jaeger collector when --tracing-exporter=jaeger.")
 	fs.StringVar(&w.DebtdbAddress, "debtdb-graphql-address", "http://localhost:8081/graphql", ""+
 		"The address of debtdb address.")
 	fs.StringVar(&w.CreditGatewayAddress, "credit-gateway-address", "http://localhost:8085/v1/graphql", ""+
 		"The address of credit-gateway address.")
 	fs.StringVar(&w.IsMock, "is-mock", "false", ""+
 		"The mock of fahai-api server.")
+	w.Logging.AddFlags(fs)
 }
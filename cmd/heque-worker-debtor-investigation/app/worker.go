@@ -1,10 +1,10 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/golang/glog"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -12,10 +12,16 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
+	"denggotech.cn/heque/heque/apiserver"
 	"denggotech.cn/heque/heque/client"
 	"denggotech.cn/heque/heque/cmd/heque-worker-debtor-investigation/app/types"
 	utilflag "denggotech.cn/heque/heque/util/flag"
+	"denggotech.cn/heque/heque/util/logging"
+	"denggotech.cn/heque/heque/util/tracing"
+	"denggotech.cn/heque/heque/worker"
+	"denggotech.cn/heque/heque/worker/filters"
 )
 
 // 人法尽调消费实体类
@@ -54,6 +60,12 @@ func NewWorkerCommand() *cobra.Command {
 		Use:  "heque-worker-debtor-investigation",
 		Long: `This worker investigates debtor.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := logging.Init(s.Logging)
+			if err != nil {
+				return err
+			}
+			defer logger.Sync()
+
 			utilflag.PrintFlags(cmd.Flags())
 
 			return Run(s)
@@ -82,6 +94,13 @@ func Run(w *WorkerOptions) error {
 		return err
 	}
 
+	// Initialize the tracing
+	shutdownTracing, err := tracing.Init("heque-worker-debtor-investigation", w.TracingExporter, w.TracingEndpoint)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize heque client
 	cli, err := client.New(client.Config{
 		Endpoints: []string{w.RedisAddress},
@@ -90,28 +109,31 @@ func Run(w *WorkerOptions) error {
 		return err
 	}
 
-	for {
-		job, err := cli.Dequeue(w.QueueName)
+	if w.RateLimit > 0 {
+		err = cli.SetRateLimit(w.QueueName, client.RateLimit{Rate: w.RateLimit, Burst: w.RateBurst})
 		if err != nil {
-			glog.Errorf("Observed a error: %s", err)
-			continue
-		}
-
-		err = consumeOneJob(job, w.DebtdbAddress, w.CreditGatewayAddress, cli)
-		if err == nil {
-			cli.MarkAsDone(job)
-		} else {
-			glog.Errorf("consume job failed: %s", err)
-			cli.MarkAsFailed(job)
+			return err
 		}
 	}
 
-	return nil
+	handler := worker.Chain(worker.HandlerFunc(func(ctx context.Context, job *client.Job) error {
+		return consumeOneJob(ctx, cli, job, w.DebtdbAddress, w.CreditGatewayAddress)
+	}), filters.WithLogging, filters.WithTracing, filters.WithPrometheusMetrics, filters.WithTiming, filters.WithPanicRecovery)
+
+	wk := worker.NewWorker(&worker.Config{
+		Client:         cli,
+		QueueName:      w.QueueName,
+		DefaultTimeout: w.JobTimeout,
+		BindAddress:    fmt.Sprintf("%s:%d", w.BindAddress, w.BindPort),
+		ReadyChecks: []worker.ReadyCheck{
+			worker.URLReadyCheck("debtdb", w.DebtdbAddress),
+			worker.URLReadyCheck("credit-gateway", w.CreditGatewayAddress),
+		},
+	}, handler)
+	return wk.Run(apiserver.SetupSignalHandler())
 }
 
-func consumeOneJob(j *client.Job, debtdbAdress string, creditGatewayAddress string, cli *client.Client) error {
-	glog.Infof("consuming job: %s", j.ID)
-
+func consumeOneJob(ctx context.Context, cli *client.Client, j *client.Job, debtdbAdress string, creditGatewayAddress string) error {
 	var jobArgs jobArgs
 
 	err := json.Unmarshal([]byte(j.Spec.Payload), &jobArgs)
@@ -124,13 +146,14 @@ func consumeOneJob(j *client.Job, debtdbAdress string, creditGatewayAddress stri
 		payloadStrQueryVal := fmt.Sprintf("{\"operationName\":null,\"variables\":{},\"query\":\"{shixin:riskPersons(name:\\\"%s\\\", idcardNo: \\\"%s\\\", domain: \\\"sifa\\\", dataType: \\\"shixin\\\") {     code     msg     shixinList{       body       dataType       entryId       sortTime       title       matchRatio       shixin{         shixinId         body         caseNo         court         postTime         sortTime         yiwu         yjCode         yjdw         dataType       }     }   }   zx:riskPersons(name: \\\"%s\\\", idcardNo: \\\"%s\\\", domain: \\\"sifa\\\", dataType: \\\"zxgg\\\") {     code     msg     zxggList{       body       dataType       entryId       sortTime       title       matchRatio       zxgg{         zxggId         address         body         caseNo         closeDate         court         proposer         sortTime         title         yjCode         yjdw       }     }   }  }\"}",
 			jobArgs.Name, jobArgs.IDNumber, jobArgs.Name, jobArgs.IDNumber)
 
-		getDebtorResponse, err := httpGraphqlInvestigationQuery(&jobArgs, payloadStrQueryVal, creditGatewayAddress)
+		getDebtorResponse, err := queryDebtor(ctx, cli, j, &jobArgs, payloadStrQueryVal, creditGatewayAddress)
 		if err != nil {
 			return err
 		}
 		if getDebtorResponse.Errors != nil {
 			return errors.New("查询人法信息出错")
 		}
+		reportProgress(ctx, cli, j, 50, "查询人法信息完成")
 
 		// graphql 写回debtdb
 		payloadStrUpdateVal := "{\"operationName\":null,\"variables\":{\"input\":{\"debtorId\":\"%s\",\"name\":\"%s\","
@@ -181,13 +204,14 @@ func consumeOneJob(j *client.Job, debtdbAdress string, creditGatewayAddress stri
 		payloadStrUpdateVal = fmt.Sprintf(payloadStrUpdateVal, args...)
 		payloadStrUpdateVal = payloadStrUpdateVal + "}},\"query\": \"mutation ($input: UpdateDebtorInput!) {updateDebtor(input: $input) { id }}\"}"
 
-		updateDebtorResponse, err := httpGraphqlInvestigationMutation(&jobArgs, payloadStrUpdateVal, debtdbAdress)
+		updateDebtorResponse, err := httpGraphqlInvestigationMutation(ctx, &jobArgs, payloadStrUpdateVal, debtdbAdress)
 		if err != nil {
 			return err
 		}
 		if updateDebtorResponse.Errors != nil {
 			return errors.New("更新债务人出错")
 		}
+		reportProgress(ctx, cli, j, 100, "更新债务人完成")
 	} else if jobArgs.Kind == "122" { // 公司：122
 		// 向信用网关查询人法信息
 		payloadStrQueryVal := fmt.Sprintf("{\"operationName\":null,\"variables\":{},\"query\":\"{\\n  enterpriseBusinessInfo(keyword: \\\"%s\\\") {\\n    id\\n    name\\n    staffNumRange\\n    fromTime\\n    type\\n    bondName\\n    isMicroEnt\\n    usedBondName\\n    regNumber\\n    percentileScore\\n    regCapital\\n    regInstitute\\n    regLocation\\n    industry\\n    approvedTime\\n    socialStaffNum\\n    tags\\n    taxNumber\\n    businessScope\\n    property3\\n    alias\\n    orgNumber\\n    regStatus\\n    estiblishTime\\n    bondType\\n    legalPersonName\\n    toTime\\n    actualCapital\\n    companyOrgType\\n    base\\n    creditCode\\n    historyNames\\n    bondNum\\n    regCapitalCurrency\\n    actualCapitalCurrency\\n    revokeDate\\n    revokeReason\\n    cancelDate\\n    cancelReason\\n  }\\n  "+
@@ -197,13 +221,14 @@ func consumeOneJob(j *client.Job, debtdbAdress string, creditGatewayAddress stri
 			"holder(name: \\\"%s\\\") {\\n    name\\n    alias\\n    capitalActl {\\n      amomon\\n      percent\\n    }\\n    capital {\\n      amomon\\n      percent\\n    }\\n    type\\n  }\\n}\\n\"}",
 			jobArgs.Name, jobArgs.Name, jobArgs.Name, jobArgs.Name, jobArgs.Name)
 
-		getDebtorResponse, err := httpGraphqlInvestigationQuery(&jobArgs, payloadStrQueryVal, creditGatewayAddress)
+		getDebtorResponse, err := queryDebtor(ctx, cli, j, &jobArgs, payloadStrQueryVal, creditGatewayAddress)
 		if err != nil {
 			return err
 		}
 		if getDebtorResponse.Errors != nil {
 			return errors.New("查询人法信息出错")
 		}
+		reportProgress(ctx, cli, j, 50, "查询人法信息完成")
 
 		// graphql 写回debtdb
 		payloadStrUpdateVal := "{\"operationName\":null,\"variables\":{\"input\":{\"debtorId\":\"%s\",\"name\":\"%s\""
@@ -404,27 +429,69 @@ func consumeOneJob(j *client.Job, debtdbAdress string, creditGatewayAddress stri
 		payloadStrUpdateVal = fmt.Sprintf(payloadStrUpdateVal, args...)
 		payloadStrUpdateVal = payloadStrUpdateVal + "]}},\"query\": \"mutation ($input: UpdateDebtorInput!) {updateDebtor(input: $input) { id }}\"}"
 
-		updateDebtorResponse, err := httpGraphqlInvestigationMutation(&jobArgs, payloadStrUpdateVal, debtdbAdress)
+		updateDebtorResponse, err := httpGraphqlInvestigationMutation(ctx, &jobArgs, payloadStrUpdateVal, debtdbAdress)
 		if err != nil {
 			return err
 		}
 		if updateDebtorResponse.Errors != nil {
 			return errors.New("更新债务人出错")
 		}
+		reportProgress(ctx, cli, j, 100, "更新债务人完成")
 	} else {
 		return errors.New("债务人类型错误")
 	}
 
-	glog.Infof("debtor investigation is done......jobId:" + j.ID)
 	return nil
 }
 
+// queryDebtor queries the credit gateway about the debtor, unless a previous
+// attempt of the job already did: the response is checkpointed, so that a
+// retry after a failed update of debtdb does not query the gateway again.
+func queryDebtor(ctx context.Context, cli *client.Client, j *client.Job, args *jobArgs, payloadStr string, url string) (*types.GetDebtorResponse, error) {
+	checkpoint, err := cli.LoadCheckpoint(j)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != "" {
+		var resp types.GetDebtorResponse
+		if err := json.Unmarshal([]byte(checkpoint), &resp); err != nil {
+			return nil, err
+		}
+		logging.FromContext(ctx).Info("resuming from checkpoint")
+		return &resp, nil
+	}
+
+	resp, err := httpGraphqlInvestigationQuery(ctx, args, payloadStr, url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Errors != nil {
+		return resp, nil
+	}
+
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := cli.SaveCheckpoint(j, string(buf)); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// reportProgress reports the progress of the job. A failure is only logged,
+// the investigation goes on.
+func reportProgress(ctx context.Context, cli *client.Client, j *client.Job, percent int, message string) {
+	if err := cli.ReportProgress(j, percent, message); err != nil {
+		logging.FromContext(ctx).Warn("failed to report progress", zap.Error(err))
+	}
+}
+
 func splitNumAndChar(str string) []string {
 	var arr []string
 	var num string
 	var zwChar string
 	for _, s := range str {
-		println(s)
 		if s < 256 {
 			num = num + string(s)
 		}
@@ -443,12 +510,11 @@ func splitNumAndChar(str string) []string {
 	return arr
 }
 
-func httpGraphqlInvestigationMutation(j *jobArgs, payloadStr string, url string) (*types.DebtorResponse, error) {
+func httpGraphqlInvestigationMutation(ctx context.Context, j *jobArgs, payloadStr string, url string) (*types.DebtorResponse, error) {
 	payload := strings.NewReader(payloadStr)
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, payload)
+	client := &http.Client{Transport: tracing.NewTransport(http.DefaultTransport)}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, payload)
 	if err != nil {
-		glog.Errorf("Observed a error :%s", err)
 		return nil, err
 	}
 
@@ -457,7 +523,6 @@ func httpGraphqlInvestigationMutation(j *jobArgs, payloadStr string, url string)
 
 	res, err := client.Do(req)
 	if err != nil {
-		glog.Errorf("Observed a error :%s", err)
 		return nil, err
 	}
 
@@ -466,7 +531,7 @@ func httpGraphqlInvestigationMutation(j *jobArgs, payloadStr string, url string)
 		if err != nil {
 			return nil, err
 		}
-		glog.Info(string(body))
+		logging.FromContext(ctx).Error("debtdb status code should be 200", zap.Int("statusCode", res.StatusCode), zap.ByteString("body", body))
 		return nil, errors.New("更新债务人出错")
 	}
 
@@ -474,26 +539,23 @@ func httpGraphqlInvestigationMutation(j *jobArgs, payloadStr string, url string)
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		glog.Info(err)
 		return nil, err
 	}
 
 	err = json.Unmarshal([]byte(string(body)), &dr)
 	if err != nil {
-		glog.Errorf("Observed a error :%s", err)
 		return nil, err
 	}
 
 	return &dr, err
 }
 
-func httpGraphqlInvestigationQuery(j *jobArgs, payloadStr string, url string) (*types.GetDebtorResponse, error) {
+func httpGraphqlInvestigationQuery(ctx context.Context, j *jobArgs, payloadStr string, url string) (*types.GetDebtorResponse, error) {
 	payload := strings.NewReader(payloadStr)
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, payload)
+	client := &http.Client{Transport: tracing.NewTransport(http.DefaultTransport)}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, payload)
 
 	if err != nil {
-		glog.Errorf("Observed a error :%s", err)
 		return nil, err
 	}
 
@@ -501,7 +563,6 @@ func httpGraphqlInvestigationQuery(j *jobArgs, payloadStr string, url string) (*
 
 	res, err := client.Do(req)
 	if err != nil {
-		glog.Errorf("Observed a error :%s", err)
 		return nil, err
 	}
 
@@ -510,7 +571,7 @@ func httpGraphqlInvestigationQuery(j *jobArgs, payloadStr string, url string) (*
 		if err != nil {
 			return nil, err
 		}
-		glog.Info(string(body))
+		logging.FromContext(ctx).Error("credit-gateway status code should be 200", zap.Int("statusCode", res.StatusCode), zap.ByteString("body", body))
 		return nil, errors.New("查询债务人报错")
 	}
 
@@ -518,13 +579,11 @@ func httpGraphqlInvestigationQuery(j *jobArgs, payloadStr string, url string) (*
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		glog.Errorf("Observed a error :%s", err)
 		return nil, err
 	}
 
 	err = json.Unmarshal([]byte(string(body)), &vresp)
 	if err != nil {
-		glog.Errorf("Observed a error :%s", err)
 		return nil, err
 	}
 
@@ -3,8 +3,13 @@ package app
 import (
 	"errors"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
+
+	"denggotech.cn/heque/heque/util/logging"
+	"denggotech.cn/heque/heque/worker"
 )
 
 // WorkerOptions runs a heque worker.
@@ -12,17 +17,25 @@ type WorkerOptions struct {
 	BindAddress      net.IP
 	BindPort         uint
 	QueueName        string
+	JobTimeout       time.Duration
+	RateLimit        float64
+	RateBurst        int
 	RedisAddress     string
+	TracingExporter  string
+	TracingEndpoint  string
 	DebtdbAddress    string
 	YunfangKeyID     string
 	YunfangAccessKey string
 	YunfangDomain    string
 	IsMock           string
+	Logging          *logging.Options
 }
 
 // NewWorkerOptions creates a new WorkerOptions object with default parameters
 func NewWorkerOptions() *WorkerOptions {
-	w := WorkerOptions{}
+	w := WorkerOptions{
+		Logging: logging.NewOptions(),
+	}
 	return &w
 }
 
@@ -31,11 +44,22 @@ func (w *WorkerOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.IPVar(&w.BindAddress, "bind-address", net.ParseIP("127.0.0.1"), ""+
 		"The IP address on which to listen for the --bind-port port.")
 	fs.UintVar(&w.BindPort, "bind-port", 8086, ""+
-		"The port on which to serve requests.")
+		"The port on which to serve the health, readiness, metrics and debug endpoints.")
 	fs.StringVar(&w.QueueName, "queue-name", "evaluate_house", ""+
 		"The name of queue.")
+	fs.DurationVar(&w.JobTimeout, "job-timeout", 5*time.Minute, ""+
+		"The execution timeout of the jobs which do not specify their own. Zero means no timeout.")
+	fs.Float64Var(&w.RateLimit, "rate-limit", 0, ""+
+		"The maximum number of jobs per second dequeued from the queue by all its workers together. "+
+		"If zero, the limit already configured for the queue, if any, is kept.")
+	fs.IntVar(&w.RateBurst, "rate-burst", 1, ""+
+		"The number of jobs that can be dequeued at once under --rate-limit.")
 	fs.StringVar(&w.RedisAddress, "redis-address", "localhost:6379", ""+
 		"The address of redis server.")
+	fs.StringVar(&w.TracingExporter, "tracing-exporter", "", ""+
+		"The exporter of the OpenTelemetry spans: \"stdout\" or \"jaeger\". If blank, tracing is disabled.")
+	fs.StringVar(&w.TracingEndpoint, "tracing-endpoint", "http://localhost:14268/api/traces", ""+
+		"The endpoint of the jaeger collector when --tracing-exporter=jaeger.")
 	fs.StringVar(&w.DebtdbAddress, "debtdb-graphql-address", "http://localhost:8081/graphql", ""+
 		"The address of debtdb address.")
 	fs.StringVar(&w.YunfangKeyID, "yunfang-keyid", "nosuchkeyid", ""+
@@ -46,6 +70,7 @@ func (w *WorkerOptions) AddFlags(fs *pflag.FlagSet) {
 		"The URI of yunfang domain.")
 	fs.StringVar(&w.IsMock, "is-mock", "false", ""+
 		"The mock of fahai-api server.")
+	w.Logging.AddFlags(fs)
 }
 
 // Validate checks WorkerOptions and return an error if it fails
@@ -64,3 +89,18 @@ func (w *WorkerOptions) Validate() error {
 
 	return nil
 }
+
+// yunfangReady checks that the yunfang credentials have been set and that
+// yunfang is reachable, unless the worker is mocking yunfang.
+func (w *WorkerOptions) yunfangReady() error {
+	if w.IsMock == "true" {
+		return nil
+	}
+
+	for _, v := range []string{w.YunfangKeyID, w.YunfangAccessKey, w.YunfangDomain} {
+		if strings.HasPrefix(v, "nosuch") {
+			return errors.New("--yunfang-keyid, --yunfang-access-key and --yunfang-domain must be configured")
+		}
+	}
+	return worker.URLReadyCheck("yunfang", w.YunfangDomain).Check()
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,14 +9,19 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
+	"denggotech.cn/heque/heque/apiserver"
 	"denggotech.cn/heque/heque/client"
 	"denggotech.cn/heque/heque/cmd/heque-worker-house-valuation/app/types"
 	utilxiaotao "denggotech.cn/heque/heque/cmd/heque-worker-house-valuation/xiaotao"
 	utilflag "denggotech.cn/heque/heque/util/flag"
+	"denggotech.cn/heque/heque/util/logging"
+	"denggotech.cn/heque/heque/util/tracing"
+	"denggotech.cn/heque/heque/worker"
+	"denggotech.cn/heque/heque/worker/filters"
 )
 
 // 云房估值消费实体类
@@ -38,6 +44,14 @@ type jobArgs struct {
 	Batch string `json:"Batch"`
 }
 
+// 估值结果
+type jobResult struct {
+	//  财产线索 ID
+	PropertyID string `json:"PropertyID"`
+	//  估值金额（元）
+	ValuationAmount float64 `json:"ValuationAmount"`
+}
+
 func NewWorkerCommand() *cobra.Command {
 	s := NewWorkerOptions()
 
@@ -45,6 +59,12 @@ func NewWorkerCommand() *cobra.Command {
 		Use:  "heque-worker-house-valuation",
 		Long: `This worker valuates house.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			logger, err := logging.Init(s.Logging)
+			if err != nil {
+				return err
+			}
+			defer logger.Sync()
+
 			utilflag.PrintFlags(cmd.Flags())
 
 			if err := s.Validate(); err != nil {
@@ -83,6 +103,13 @@ func Run(w *WorkerOptions) error {
 		return err
 	}
 
+	// Initialize the tracing
+	shutdownTracing, err := tracing.Init("heque-worker-house-valuation", w.TracingExporter, w.TracingEndpoint)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize heque client
 	cli, err := client.New(client.Config{
 		Endpoints: []string{w.RedisAddress},
@@ -91,41 +118,45 @@ func Run(w *WorkerOptions) error {
 		return err
 	}
 
-	for {
-		job, err := cli.Dequeue(w.QueueName)
+	if w.RateLimit > 0 {
+		err = cli.SetRateLimit(w.QueueName, client.RateLimit{Rate: w.RateLimit, Burst: w.RateBurst})
 		if err != nil {
-			fmt.Println(err)
-			continue
+			return err
 		}
-		consumeOneJob(job, w.DebtdbAddress, cli)
-
-		// 以后云房估值调用后可以去除sleep，现在模拟进度条间隔1秒
-		time.Sleep(1 * time.Second)
 	}
 
-	return nil
+	handler := worker.Chain(worker.HandlerFunc(func(ctx context.Context, job *client.Job) error {
+		return consumeOneJob(ctx, job, w.DebtdbAddress)
+	}), filters.WithLogging, filters.WithTracing, filters.WithPrometheusMetrics, filters.WithTiming, filters.WithPanicRecovery)
+
+	wk := worker.NewWorker(&worker.Config{
+		Client:         cli,
+		QueueName:      w.QueueName,
+		DefaultTimeout: w.JobTimeout,
+		BindAddress:    fmt.Sprintf("%s:%d", w.BindAddress, w.BindPort),
+		ReadyChecks: []worker.ReadyCheck{
+			{Name: "yunfang", Check: w.yunfangReady},
+			worker.URLReadyCheck("debtdb", w.DebtdbAddress),
+		},
+	}, handler)
+	return wk.Run(apiserver.SetupSignalHandler())
 }
 
-func consumeOneJob(j *client.Job, debtdbAdress string, cli *client.Client) error {
+func consumeOneJob(ctx context.Context, j *client.Job, debtdbAdress string) error {
 	var jobArgs jobArgs
 
 	err := json.Unmarshal([]byte(j.Spec.Payload), &jobArgs)
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 
 	// 估值
 	area, err := strconv.ParseFloat(jobArgs.Area, 64)
 	if err != nil {
-		cli.MarkAsFailed(j)
-		fmt.Println(err)
 		return err
 	}
-	valuationAmount, err := valuateHouse(jobArgs.Address, area, jobArgs.CityCode, jobArgs.Type)
+	valuationAmount, err := valuateHouse(ctx, jobArgs.Address, area, jobArgs.CityCode, jobArgs.Type)
 	if err != nil {
-		cli.MarkAsFailed(j)
-		fmt.Println(err)
 		return err
 	}
 
@@ -135,29 +166,34 @@ func consumeOneJob(j *client.Job, debtdbAdress string, cli *client.Client) error
 	// graphql 估值写回debtdb
 	payloadStrUpdateVal := fmt.Sprintf("{\"query\":\"mutation ($input: UpdateHouseValuationInput!) {updateHouseValuation (input: $input) {valuation}}\",\"variables\":{\"input\":{\"houseId\":\"%s\",\"valuationAmount\":\"%f\"}}}", jobArgs.PropertyID, valuationAmount)
 
-	updateValuationResponse, err := httpGraphqlValuationMutation(&jobArgs, payloadStrUpdateVal, url)
+	updateValuationResponse, err := httpGraphqlValuationMutation(ctx, &jobArgs, payloadStrUpdateVal, url)
 	if err != nil {
-		cli.MarkAsFailed(j)
 		return err
 	}
 	if updateValuationResponse.Errors != nil {
-		cli.MarkAsFailed(j)
-		fmt.Println(updateValuationResponse.Errors)
+		logging.FromContext(ctx).Error("debtdb returned errors", zap.Any("errors", updateValuationResponse.Errors))
 		return errors.New("更新估值报错")
 	}
 
-	cli.MarkAsDone(j)
-	fmt.Println("房屋估值结束......jobId:" + j.ID)
+	// 估值结果，供同步调用方获取
+	result, err := json.Marshal(jobResult{
+		PropertyID:      jobArgs.PropertyID,
+		ValuationAmount: valuationAmount,
+	})
+	if err != nil {
+		return err
+	}
+	j.Status.Result = string(result)
+
 	return nil
 }
 
-func httpGraphqlValuationMutation(j *jobArgs, payloadStr string, url string) (*types.ValuationResponse, error) {
+func httpGraphqlValuationMutation(ctx context.Context, j *jobArgs, payloadStr string, url string) (*types.ValuationResponse, error) {
 	payload := strings.NewReader(payloadStr)
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, payload)
+	client := &http.Client{Transport: tracing.NewTransport(http.DefaultTransport)}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, payload)
 
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
@@ -166,7 +202,6 @@ func httpGraphqlValuationMutation(j *jobArgs, payloadStr string, url string) (*t
 
 	res, err := client.Do(req)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
@@ -175,7 +210,7 @@ func httpGraphqlValuationMutation(j *jobArgs, payloadStr string, url string) (*t
 		if err != nil {
 			return nil, err
 		}
-		fmt.Println(string(body))
+		logging.FromContext(ctx).Error("debtdb status code should be 200", zap.Int("statusCode", res.StatusCode), zap.ByteString("body", body))
 		return nil, errors.New("查询速效价报错")
 	}
 
@@ -183,27 +218,24 @@ func httpGraphqlValuationMutation(j *jobArgs, payloadStr string, url string) (*t
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
-	fmt.Println(string(body))
+	logging.FromContext(ctx).Debug("debtdb response", zap.ByteString("body", body))
 	err = json.Unmarshal([]byte(string(body)), &vresp)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
 	return &vresp, err
 }
 
-func httpGraphqlValuationQuery(j *jobArgs, payloadStr string, url string) (*types.GetValuationResponse, error) {
+func httpGraphqlValuationQuery(ctx context.Context, j *jobArgs, payloadStr string, url string) (*types.GetValuationResponse, error) {
 	payload := strings.NewReader(payloadStr)
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", url, payload)
+	client := &http.Client{Transport: tracing.NewTransport(http.DefaultTransport)}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, payload)
 
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
@@ -212,7 +244,6 @@ func httpGraphqlValuationQuery(j *jobArgs, payloadStr string, url string) (*type
 
 	res, err := client.Do(req)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
@@ -221,7 +252,7 @@ func httpGraphqlValuationQuery(j *jobArgs, payloadStr string, url string) (*type
 		if err != nil {
 			return nil, err
 		}
-		fmt.Println(string(body))
+		logging.FromContext(ctx).Error("debtdb status code should be 200", zap.Int("statusCode", res.StatusCode), zap.ByteString("body", body))
 		return nil, errors.New("查询速效价报错")
 	}
 
@@ -229,14 +260,12 @@ func httpGraphqlValuationQuery(j *jobArgs, payloadStr string, url string) (*type
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
-	fmt.Println(string(body))
+	logging.FromContext(ctx).Debug("debtdb response", zap.ByteString("body", body))
 	err = json.Unmarshal([]byte(string(body)), &vresp)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
@@ -244,13 +273,13 @@ func httpGraphqlValuationQuery(j *jobArgs, payloadStr string, url string) (*type
 }
 
 // 云房估值接口
-func valuateHouse(address string, area float64, cityCode string, houseType string) (float64, error) {
+func valuateHouse(ctx context.Context, address string, area float64, cityCode string, houseType string) (float64, error) {
 
 	if isMock == "true" {
 		totalPrice := 1 * 10000
 		return float64(totalPrice), nil
 	} else {
-		val, err := utilxiaotao.Valuate(address, cityCode, area, houseType)
+		val, err := utilxiaotao.Valuate(ctx, address, cityCode, area, houseType)
 		if err != nil {
 			return 0, err
 		}
@@ -1,6 +1,7 @@
 package xiaotao
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
@@ -10,6 +11,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"denggotech.cn/heque/heque/util/tracing"
 )
 
 var (
@@ -18,6 +21,9 @@ var (
 	domainYunfang   string
 )
 
+// httpClient traces the requests to yunfang.
+var httpClient = &http.Client{Transport: tracing.NewTransport(http.DefaultTransport)}
+
 // Init keyID, accessKey, domain
 func Init(keyID string, accessKey []byte, domain string) error {
 	keyId = keyID
@@ -88,8 +94,9 @@ type GetCommunityRatingResponse struct {
 	Success   bool
 }
 
-// Get signs the request and issues a GET to the specified URL.
-func Get(rawurl string) (*http.Response, error) {
+// Get signs the request and issues a GET to the specified URL. The request
+// is cancelled when ctx is done.
+func Get(ctx context.Context, rawurl string) (*http.Response, error) {
 	u, err := url.Parse(domainYunfang + rawurl)
 	if err != nil {
 		return nil, err
@@ -120,7 +127,11 @@ func Get(rawurl string) (*http.Response, error) {
 	mac.Write([]byte(plain))
 	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
 
-	return http.Get(domainYunfang + rawurl + "&userKeyId=" + keyId + "&timeStamp=" + now + "&accessSignature=" + urlEncode(signature))
+	req, err := http.NewRequestWithContext(ctx, "GET", domainYunfang+rawurl+"&userKeyId="+keyId+"&timeStamp="+now+"&accessSignature="+urlEncode(signature), nil)
+	if err != nil {
+		return nil, err
+	}
+	return httpClient.Do(req)
 }
 
 // url中的特殊字符进行转义
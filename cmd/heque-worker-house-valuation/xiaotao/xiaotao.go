@@ -2,6 +2,7 @@ package xiaotao
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,7 +12,9 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/golang/glog"
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/util/logging"
 )
 
 // ValuateResult is the return value of Valuate.
@@ -21,19 +24,21 @@ type ValuateResult struct {
 }
 
 // Valuate 估值
-func Valuate(address string, cityCode string, area float64, kind string) (*ValuateResult, error) {
-	glog.Infof("xiaotao: Valuate(%q, %q, %d, %q", address, cityCode, area, kind)
+func Valuate(ctx context.Context, address string, cityCode string, area float64, kind string) (*ValuateResult, error) {
+	logger := logging.FromContext(ctx).Named("xiaotao")
+	logger.Info("Valuate", zap.String("address", address), zap.String("cityCode", cityCode), zap.Float64("area", area), zap.String("kind", kind))
 
 	if kind != "住宅" {
 		return nil, errors.New("ethan: 小淘现在只能帮您计算「住宅」的估值")
 	}
 
 	rawres, err := Get(
-		"/general/price/getEnquiryPrice/v3?" +
-			"cityCode=" + cityCode +
-			"&address=" + url.QueryEscape(address) +
-			"&houseType=" + url.QueryEscape(kind) +
-			"&buildingArea=" + strconv.FormatFloat(area, 'f', -1, 64))
+		ctx,
+		"/general/price/getEnquiryPrice/v3?"+
+			"cityCode="+cityCode+
+			"&address="+url.QueryEscape(address)+
+			"&houseType="+url.QueryEscape(kind)+
+			"&buildingArea="+strconv.FormatFloat(area, 'f', -1, 64))
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +49,7 @@ func Valuate(address string, cityCode string, area float64, kind string) (*Valua
 		if err != nil {
 			return nil, err
 		}
-		glog.Errorf("xiaotao: downstream status code %d (should be 200), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream status code should be 200", zap.Int("statusCode", rawres.StatusCode), zap.ByteString("body", buf))
 		return nil, fmt.Errorf("downstream status code: %d (should be 200)", rawres.StatusCode)
 	}
 
@@ -54,7 +59,7 @@ func Valuate(address string, cityCode string, area float64, kind string) (*Valua
 		if err != nil {
 			return nil, err
 		}
-		glog.Errorf("xiaotao: downstream content type %s (should be application/json), body: %s", ct, string(buf))
+		logger.Error("downstream content type should be application/json", zap.String("contentType", ct), zap.ByteString("body", buf))
 		return nil, fmt.Errorf("downstream content type: %s (should be application/json)", ct)
 	}
 
@@ -64,12 +69,12 @@ func Valuate(address string, cityCode string, area float64, kind string) (*Valua
 	}
 
 	if res.ErrorCode != "OK" {
-		glog.Errorf("xiaotao: downstream error code %q (should be 'OK'), response: %v", res.ErrorCode, res)
+		logger.Error("downstream error code should be 'OK'", zap.String("errorCode", res.ErrorCode), zap.Any("response", res))
 		return nil, fmt.Errorf("downstream error code: %q (should be 'OK')", res.ErrorCode)
 	}
 
 	if res.Data[0].StatusCode != "ENQUIRY-SUCCESS" {
-		glog.Errorf("xiaotao: downstream status code %q (should be 'ENQUIRY-SUCCESS'), response: %v", res.Data[0].StatusCode, res)
+		logger.Error("downstream status code should be 'ENQUIRY-SUCCESS'", zap.String("statusCode", res.Data[0].StatusCode), zap.Any("response", res))
 		return nil, fmt.Errorf("downstream status code: %q (should be 'ENQUIRY-SUCCESS')", res.Data[0].StatusCode)
 	}
 
@@ -80,13 +85,15 @@ func Valuate(address string, cityCode string, area float64, kind string) (*Valua
 }
 
 // AcquireNeighboringPriceMap 获取抵押物周边房价地图
-func AcquireNeighboringPriceMap(cityCode, communityID string) (string, error) {
-	glog.Infof("xiaotao: AcquireNeighboringPriceMap(%q, %q)", cityCode, communityID)
+func AcquireNeighboringPriceMap(ctx context.Context, cityCode, communityID string) (string, error) {
+	logger := logging.FromContext(ctx).Named("xiaotao")
+	logger.Info("AcquireNeighboringPriceMap", zap.String("cityCode", cityCode), zap.String("communityID", communityID))
 
 	rawres, err := Get(
-		"/general/price/getCommunityPeripheryAvgeragePrice/v3?" +
-			"cityCode=" + cityCode +
-			"&communityID=" + url.QueryEscape(communityID) +
+		ctx,
+		"/general/price/getCommunityPeripheryAvgeragePrice/v3?"+
+			"cityCode="+cityCode+
+			"&communityID="+url.QueryEscape(communityID)+
 			"&distance=500")
 	if err != nil {
 		return "", err
@@ -98,7 +105,7 @@ func AcquireNeighboringPriceMap(cityCode, communityID string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream status code %d (should be 200), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream status code should be 200", zap.Int("statusCode", rawres.StatusCode), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream status code: %d (should be 200)", rawres.StatusCode)
 	}
 
@@ -108,7 +115,7 @@ func AcquireNeighboringPriceMap(cityCode, communityID string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream content type %s (should be application/json), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream content type should be application/json", zap.String("contentType", ct), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream content type: %s (should be application/json)", ct)
 	}
 
@@ -118,7 +125,7 @@ func AcquireNeighboringPriceMap(cityCode, communityID string) (string, error) {
 	}
 
 	if res.ErrorCode != "OK" {
-		glog.Errorf("xiaotao: downstream error code %q (should be 'OK'), response: %v", res.ErrorCode, res)
+		logger.Error("downstream error code should be 'OK'", zap.String("errorCode", res.ErrorCode), zap.Any("response", res))
 		return "", fmt.Errorf("downstream error code: %q (should be 'OK')", res.ErrorCode)
 	}
 
@@ -131,13 +138,15 @@ func AcquireNeighboringPriceMap(cityCode, communityID string) (string, error) {
 }
 
 // AcquireCommunityInfo 获取抵押物所在小区的信息
-func AcquireCommunityInfo(cityCode, communityID string) (string, error) {
-	glog.Infof("xiaotao: AcquireCommunityInfo(%q, %q)", cityCode, communityID)
+func AcquireCommunityInfo(ctx context.Context, cityCode, communityID string) (string, error) {
+	logger := logging.FromContext(ctx).Named("xiaotao")
+	logger.Info("AcquireCommunityInfo", zap.String("cityCode", cityCode), zap.String("communityID", communityID))
 
 	rawres, err := Get(
-		"/general/baseinfo/getCommunityBasicInfo/v3?" +
-			"cityCode=" + cityCode +
-			"&communityID=" + url.QueryEscape(communityID))
+		ctx,
+		"/general/baseinfo/getCommunityBasicInfo/v3?"+
+			"cityCode="+cityCode+
+			"&communityID="+url.QueryEscape(communityID))
 	if err != nil {
 		return "", err
 	}
@@ -148,7 +157,7 @@ func AcquireCommunityInfo(cityCode, communityID string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream status code %d (should be 200), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream status code should be 200", zap.Int("statusCode", rawres.StatusCode), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream status code: %d (should be 200)", rawres.StatusCode)
 	}
 
@@ -158,7 +167,7 @@ func AcquireCommunityInfo(cityCode, communityID string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream content type %s (should be application/json), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream content type should be application/json", zap.String("contentType", ct), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream content type: %s (should be application/json)", ct)
 	}
 
@@ -171,13 +180,15 @@ func AcquireCommunityInfo(cityCode, communityID string) (string, error) {
 }
 
 // AcquireResidentialFacilities 获取抵押物周边配套设施
-func AcquireResidentialFacilities(cityCode, communityID string) (string, error) {
-	glog.Infof("xiaotao: AcquireResidentialFacilities(%q, %q)", cityCode, communityID)
+func AcquireResidentialFacilities(ctx context.Context, cityCode, communityID string) (string, error) {
+	logger := logging.FromContext(ctx).Named("xiaotao")
+	logger.Info("AcquireResidentialFacilities", zap.String("cityCode", cityCode), zap.String("communityID", communityID))
 
 	rawres, err := Get(
-		"/general/baseinfo/getCommunitySupporting/v3?" +
-			"cityCode=" + cityCode +
-			"&communityID=" + url.QueryEscape(communityID) +
+		ctx,
+		"/general/baseinfo/getCommunitySupporting/v3?"+
+			"cityCode="+cityCode+
+			"&communityID="+url.QueryEscape(communityID)+
 			"&distance=2000")
 	if err != nil {
 		return "", err
@@ -189,7 +200,7 @@ func AcquireResidentialFacilities(cityCode, communityID string) (string, error)
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream status code %d (should be 200), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream status code should be 200", zap.Int("statusCode", rawres.StatusCode), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream status code: %d (should be 200)", rawres.StatusCode)
 	}
 
@@ -199,7 +210,7 @@ func AcquireResidentialFacilities(cityCode, communityID string) (string, error)
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream content type %s (should be application/json), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream content type should be application/json", zap.String("contentType", ct), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream content type: %s (should be application/json)", ct)
 	}
 
@@ -212,13 +223,15 @@ func AcquireResidentialFacilities(cityCode, communityID string) (string, error)
 }
 
 // AcquirePawnCommunitySecondHandHousingTransactions 获取抵押物所在小区的二手房成交案例
-func AcquirePawnCommunitySecondHandHousingTransactions(cityCode, communityID string) (string, error) {
-	glog.Infof("xiaotao: AcquirePawnCommunitySecondHandHousingTransactions(%q, %q)", cityCode, communityID)
+func AcquirePawnCommunitySecondHandHousingTransactions(ctx context.Context, cityCode, communityID string) (string, error) {
+	logger := logging.FromContext(ctx).Named("xiaotao")
+	logger.Info("AcquirePawnCommunitySecondHandHousingTransactions", zap.String("cityCode", cityCode), zap.String("communityID", communityID))
 
 	rawres, err := Get(
-		"/general/collateral/getSecondHandHousingDistrictTransactionCase/v3?" +
-			"cityCode=" + cityCode +
-			"&communityID=" + url.QueryEscape(communityID))
+		ctx,
+		"/general/collateral/getSecondHandHousingDistrictTransactionCase/v3?"+
+			"cityCode="+cityCode+
+			"&communityID="+url.QueryEscape(communityID))
 	if err != nil {
 		return "", err
 	}
@@ -229,7 +242,7 @@ func AcquirePawnCommunitySecondHandHousingTransactions(cityCode, communityID str
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream status code %d (should be 200), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream status code should be 200", zap.Int("statusCode", rawres.StatusCode), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream status code: %d (should be 200)", rawres.StatusCode)
 	}
 
@@ -239,7 +252,7 @@ func AcquirePawnCommunitySecondHandHousingTransactions(cityCode, communityID str
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream content type %s (should be application/json), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream content type should be application/json", zap.String("contentType", ct), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream content type: %s (should be application/json)", ct)
 	}
 
@@ -252,13 +265,15 @@ func AcquirePawnCommunitySecondHandHousingTransactions(cityCode, communityID str
 }
 
 // AcquirePawnAveragePriceTrend 获取抵押物所在小区、行政区、城市的均值走势
-func AcquirePawnAveragePriceTrend(cityCode, communityID string) (string, error) {
-	glog.Infof("xiaotao: AcquirePawnAveragePriceTrend(%q, %q)", cityCode, communityID)
+func AcquirePawnAveragePriceTrend(ctx context.Context, cityCode, communityID string) (string, error) {
+	logger := logging.FromContext(ctx).Named("xiaotao")
+	logger.Info("AcquirePawnAveragePriceTrend", zap.String("cityCode", cityCode), zap.String("communityID", communityID))
 
 	rawres, err := Get(
-		"/general/price/getAveragePriceOfUrbanDistrictTrend/v3?" +
-			"cityCode=" + cityCode +
-			"&communityID=" + url.QueryEscape(communityID) +
+		ctx,
+		"/general/price/getAveragePriceOfUrbanDistrictTrend/v3?"+
+			"cityCode="+cityCode+
+			"&communityID="+url.QueryEscape(communityID)+
 			"&timeSpan=12")
 	if err != nil {
 		return "", err
@@ -270,7 +285,7 @@ func AcquirePawnAveragePriceTrend(cityCode, communityID string) (string, error)
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream status code %d (should be 200), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream status code should be 200", zap.Int("statusCode", rawres.StatusCode), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream status code: %d (should be 200)", rawres.StatusCode)
 	}
 
@@ -280,7 +295,7 @@ func AcquirePawnAveragePriceTrend(cityCode, communityID string) (string, error)
 		if err != nil {
 			return "", err
 		}
-		glog.Errorf("xiaotao: downstream content type %s (should be application/json), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream content type should be application/json", zap.String("contentType", ct), zap.ByteString("body", buf))
 		return "", fmt.Errorf("downstream content type: %s (should be application/json)", ct)
 	}
 
@@ -293,13 +308,15 @@ func AcquirePawnAveragePriceTrend(cityCode, communityID string) (string, error)
 }
 
 // AcquireCommunityRating 获取小区评级
-func AcquireCommunityRating(cityCode, communityID string) (*GetCommunityRatingResponseData, error) {
-	glog.Infof("xiaotao: AcquireCommunityRating(%q, %q)", cityCode, communityID)
+func AcquireCommunityRating(ctx context.Context, cityCode, communityID string) (*GetCommunityRatingResponseData, error) {
+	logger := logging.FromContext(ctx).Named("xiaotao")
+	logger.Info("AcquireCommunityRating", zap.String("cityCode", cityCode), zap.String("communityID", communityID))
 
 	rawres, err := Get(
-		"/general/grade/getCommunityRating/v3?" +
-			"cityCode=" + cityCode +
-			"&communityID=" + url.QueryEscape(communityID))
+		ctx,
+		"/general/grade/getCommunityRating/v3?"+
+			"cityCode="+cityCode+
+			"&communityID="+url.QueryEscape(communityID))
 	if err != nil {
 		return nil, err
 	}
@@ -310,7 +327,7 @@ func AcquireCommunityRating(cityCode, communityID string) (*GetCommunityRatingRe
 		if err != nil {
 			return nil, err
 		}
-		glog.Errorf("xiaotao: downstream status code %d (should be 200), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream status code should be 200", zap.Int("statusCode", rawres.StatusCode), zap.ByteString("body", buf))
 		return nil, fmt.Errorf("downstream status code: %d (should be 200)", rawres.StatusCode)
 	}
 
@@ -320,7 +337,7 @@ func AcquireCommunityRating(cityCode, communityID string) (*GetCommunityRatingRe
 		if err != nil {
 			return nil, err
 		}
-		glog.Errorf("xiaotao: downstream content type %s (should be application/json), body: %s", rawres.StatusCode, string(buf))
+		logger.Error("downstream content type should be application/json", zap.String("contentType", ct), zap.ByteString("body", buf))
 		return nil, fmt.Errorf("downstream content type: %s (should be application/json)", ct)
 	}
 
@@ -330,7 +347,7 @@ func AcquireCommunityRating(cityCode, communityID string) (*GetCommunityRatingRe
 	}
 
 	if res.ErrorCode != "OK" {
-		glog.Errorf("xiaotao: downstream error code %q (should be 'OK'), response: %v", res.ErrorCode, res)
+		logger.Error("downstream error code should be 'OK'", zap.String("errorCode", res.ErrorCode), zap.Any("response", res))
 		return nil, fmt.Errorf("downstream error code: %q (should be 'OK')", res.ErrorCode)
 	}
 
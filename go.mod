@@ -1,17 +1,30 @@
 module denggotech.cn/heque/heque
 
-go 1.13
+go 1.15
 
 require (
 	github.com/coreos/etcd v3.3.20+incompatible // indirect
 	github.com/emicklei/go-restful/v3 v3.1.0
 	github.com/go-redis/redis/v7 v7.2.0
-	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/google/uuid v1.1.1
-	github.com/json-iterator/go v1.1.9 // indirect
 	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/prometheus/client_golang v1.7.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/spf13/cobra v0.0.7
 	github.com/spf13/pflag v1.0.3
 	go.etcd.io/etcd v3.3.20+incompatible
-	sigs.k8s.io/yaml v1.2.0 // indirect
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/jaeger v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	go.uber.org/zap v1.10.0
+	sigs.k8s.io/yaml v1.2.0
+)
+
+replace (
+	// etcd 3.3 imports bbolt by its old path
+	github.com/coreos/bbolt => go.etcd.io/bbolt v1.3.5
+	// the embedded etcd server of the tests panics with the APIv2 of protobuf
+	github.com/golang/protobuf => github.com/golang/protobuf v1.3.5
 )
@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/client"
+)
+
+const (
+	// DefaultInterval is how often the scheduler checks the schedules.
+	DefaultInterval = time.Second
+	// DefaultLeaseTTL is how long the leadership outlives the last check of
+	// the leader.
+	DefaultLeaseTTL = 15 * time.Second
+)
+
+// Config is a structure used to configure a Scheduler.
+type Config struct {
+	// Client is the heque client used to read the schedules and enqueue
+	// their jobs.
+	Client *client.Client
+	// Interval is how often the scheduler checks the schedules.
+	Interval time.Duration
+	// LeaseTTL is how long the leadership outlives the last check of the
+	// leader, i.e. how long the schedules may not fire after the leader dies.
+	LeaseTTL time.Duration
+	// Logger is the logger of the scheduler. If nil, the global logger is
+	// used.
+	Logger *zap.Logger
+}
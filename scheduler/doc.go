@@ -0,0 +1,5 @@
+// Package scheduler contains the scheduler of heque, which enqueues the jobs
+// of the schedules stored in redis at the times given by their cron
+// expressions. Several schedulers can run for availability: they elect a
+// leader through a lock in redis, and only the leader enqueues jobs.
+package scheduler
@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"io/ioutil"
+
+	"sigs.k8s.io/yaml"
+
+	"denggotech.cn/heque/heque/client"
+)
+
+// File is the format of a schedules file, e.g.
+//
+//	schedules:
+//	- name: revaluate-houses
+//	  cron: "0 2 * * *"
+//	  queueName: evaluate_house
+//	  payload: '{"PkgID": "..."}'
+type File struct {
+	Schedules []*client.Schedule `json:"schedules"`
+}
+
+// LoadFile reads and validates the schedules of a YAML or JSON file.
+func LoadFile(path string) ([]*client.Schedule, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if err := yaml.UnmarshalStrict(buf, &f); err != nil {
+		return nil, err
+	}
+
+	for _, sched := range f.Schedules {
+		if err := Validate(sched); err != nil {
+			return nil, err
+		}
+	}
+	return f.Schedules, nil
+}
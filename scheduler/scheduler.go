@@ -0,0 +1,182 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/client"
+	utilruntime "denggotech.cn/heque/heque/util/runtime"
+	"denggotech.cn/heque/heque/util/uuid"
+)
+
+// leaderLock is the lock held by the leader of the schedulers.
+const leaderLock = "scheduler"
+
+// Scheduler enqueues the jobs of the schedules when they are due.
+type Scheduler struct {
+	cfg    *Config
+	id     string
+	logger *zap.Logger
+	leader bool
+
+	// crons caches the parsed cron expressions, and the errors of the
+	// invalid ones so that they are reported once.
+	crons map[string]cronEntry
+}
+
+type cronEntry struct {
+	schedule cron.Schedule
+	err      error
+}
+
+// NewScheduler creates and initializes a new Scheduler object.
+func NewScheduler(cfg *Config) *Scheduler {
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.LeaseTTL == 0 {
+		cfg.LeaseTTL = DefaultLeaseTTL
+	}
+
+	hostname, _ := os.Hostname()
+	id := fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewUUID()[:8])
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = zap.L()
+	}
+
+	return &Scheduler{
+		cfg:    cfg,
+		id:     id,
+		logger: logger.With(zap.String("scheduler", id)),
+		crons:  make(map[string]cronEntry),
+	}
+}
+
+// Run checks the schedules every interval until stopCh is closed. Only the
+// leader of the schedulers enqueues jobs; the others stand by to take over
+// when the lease of the leader expires.
+func (s *Scheduler) Run(stopCh <-chan struct{}) error {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(time.Now())
+
+		select {
+		case <-stopCh:
+			if s.leader {
+				s.logger.Info("releasing leadership")
+				return s.cfg.Client.Unlock(leaderLock, s.id)
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	leader, err := s.cfg.Client.TryLock(leaderLock, s.id, s.cfg.LeaseTTL)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	if leader != s.leader {
+		if leader {
+			s.logger.Info("became leader")
+		} else {
+			s.logger.Info("lost leadership")
+		}
+		s.leader = leader
+	}
+	if !leader {
+		return
+	}
+
+	schedules, err := s.cfg.Client.Schedules()
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, sched := range schedules {
+		utilruntime.HandleError(s.fire(sched, now))
+	}
+}
+
+// fire enqueues the job of the schedule if it is due, and records the time
+// of the next run, atomically and only while the scheduler is the leader.
+// Runs missed while no scheduler was leader are skipped, except the last
+// one.
+func (s *Scheduler) fire(sched *client.Schedule, now time.Time) error {
+	cs, ok := s.parse(sched)
+	if !ok {
+		return nil
+	}
+
+	if sched.NextRun == nil {
+		// new or updated schedule
+		return s.cfg.Client.RecordScheduleRun(sched.Name, time.Time{}, cs.Next(now))
+	}
+	if now.Before(*sched.NextRun) {
+		return nil
+	}
+
+	batch := sched.Batch
+	if batch == "" {
+		batch = sched.Name
+	}
+	batch += "-" + sched.NextRun.Format("20060102T1504")
+
+	// the run is claimed with the enqueueing, so that it is not fired
+	// again by another leader
+	job, err := s.cfg.Client.FireSchedule(sched.Name, *sched.NextRun, cs.Next(now), leaderLock, s.id, client.JobSpec{
+		Payload:    sched.Payload,
+		QueueName:  sched.QueueName,
+		Batch:      batch,
+		MaxRetries: sched.MaxRetries,
+	})
+	if err == client.ErrScheduleRunClaimed {
+		s.logger.Info("schedule run already claimed", zap.String("schedule", sched.Name))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.logger.Info("schedule fired",
+		zap.String("schedule", sched.Name),
+		zap.String("job", job.ID),
+		zap.String("queue", sched.QueueName),
+		zap.String("batch", batch))
+	return nil
+}
+
+func (s *Scheduler) parse(sched *client.Schedule) (cron.Schedule, bool) {
+	entry, ok := s.crons[sched.Cron]
+	if !ok {
+		entry.schedule, entry.err = cron.ParseStandard(sched.Cron)
+		if entry.err != nil {
+			s.logger.Error("invalid cron expression", zap.String("schedule", sched.Name), zap.String("cron", sched.Cron), zap.Error(entry.err))
+		}
+		s.crons[sched.Cron] = entry
+	}
+	return entry.schedule, entry.err == nil
+}
+
+// Validate checks a schedule and return an error if it fails
+func Validate(sched *client.Schedule) error {
+	if sched.Name == "" {
+		return fmt.Errorf("schedule without name")
+	}
+	if sched.QueueName == "" {
+		return fmt.Errorf("schedule %s: queueName must be specified", sched.Name)
+	}
+	if _, err := cron.ParseStandard(sched.Cron); err != nil {
+		return fmt.Errorf("schedule %s: %v", sched.Name, err)
+	}
+	return nil
+}
@@ -1,13 +1,15 @@
 package flag
 
 import (
-	"github.com/golang/glog"
+	"fmt"
+
 	"github.com/spf13/pflag"
+	"go.uber.org/zap"
 )
 
 // PrintFlags logs the flags in the flagset
 func PrintFlags(flags *pflag.FlagSet) {
 	flags.VisitAll(func(flag *pflag.Flag) {
-		glog.V(1).Infof("FLAG: --%s=%q", flag.Name, flag.Value)
+		zap.L().Debug(fmt.Sprintf("FLAG: --%s=%q", flag.Name, flag.Value))
 	})
 }
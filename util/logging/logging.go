@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// These are the supported formats of the logs.
+const (
+	// FormatText writes human readable lines.
+	FormatText = "text"
+	// FormatJSON writes one JSON object per line, for log collectors.
+	FormatJSON = "json"
+)
+
+// Options configures the logger of a command.
+type Options struct {
+	Level  string
+	Format string
+}
+
+// NewOptions creates a new Options object with default parameters
+func NewOptions() *Options {
+	return &Options{
+		Level:  "info",
+		Format: FormatText,
+	}
+}
+
+// AddFlags adds flags for the logger to the specified FlagSet
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Level, "log-level", o.Level, ""+
+		"The minimum level of the logs: debug, info, warn or error.")
+	fs.StringVar(&o.Format, "log-format", o.Format, ""+
+		"The format of the logs: \"text\" or \"json\".")
+}
+
+// Validate checks Options and return an error if it fails
+func (o *Options) Validate() error {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(o.Level)); err != nil {
+		return fmt.Errorf("--log-level: %v", err)
+	}
+
+	if o.Format != FormatText && o.Format != FormatJSON {
+		return fmt.Errorf("--log-format must be %q or %q", FormatText, FormatJSON)
+	}
+	return nil
+}
+
+// Init builds the logger of the options, writing to stderr, and installs it
+// as the global logger, which the packages of heque log to by default. The
+// standard library logger is redirected to it too.
+func Init(o *Options) (*zap.Logger, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	cfg := zap.NewProductionConfig()
+	if err := cfg.Level.UnmarshalText([]byte(o.Level)); err != nil {
+		return nil, err
+	}
+	// every line of a job matters, do not drop any
+	cfg.Sampling = nil
+	cfg.DisableStacktrace = true
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	if o.Format == FormatText {
+		cfg.Encoding = "console"
+		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	}
+
+	logger, err := cfg.Build()
+	if err != nil {
+		return nil, err
+	}
+	zap.ReplaceGlobals(logger)
+	zap.RedirectStdLog(logger)
+	return logger, nil
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying logger, e.g. a logger with the fields of
+// the job being processed.
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the global logger.
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.L()
+}
@@ -1,11 +1,10 @@
 package redis
 
 import (
-	"fmt"
-	"log"
 	"time"
 
 	"github.com/go-redis/redis/v7"
+	"go.uber.org/zap"
 )
 
 /**
@@ -20,13 +19,13 @@ var gClient *redis.Client
 func Set(key, value string, time int) error {
 	res := gClient.Do("SET", key, value)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Set"), zap.Error(res.Err()))
 		return res.Err()
 	}
 
 	res = gClient.Do("expire", key, time)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Set"), zap.Error(res.Err()))
 		return res.Err()
 	}
 	return nil
@@ -36,7 +35,7 @@ func Set(key, value string, time int) error {
 func SetString(key, value string) error {
 	res := gClient.Do("SET", key, value)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "SetString"), zap.Error(res.Err()))
 		return res.Err()
 	}
 	return nil
@@ -46,7 +45,7 @@ func SetString(key, value string) error {
 func GetString(key string) (string, error) {
 	res := gClient.Do("GET", key)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "GetString"), zap.Error(res.Err()))
 		return "", res.Err()
 	}
 	return res.String(), nil
@@ -55,13 +54,13 @@ func GetString(key string) (string, error) {
 func Exist(key string) (bool, error) {
 	res := gClient.Do("EXISTS", key)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Exist"), zap.Error(res.Err()))
 		return false, res.Err()
 	}
 
 	value, err := res.Bool()
 	if err != nil {
-		log.Println(err)
+		zap.L().Error("redis command failed", zap.String("func", "Exist"), zap.Error(err))
 		return false, err
 	}
 	return value, nil
@@ -71,13 +70,13 @@ func Exist(key string) (bool, error) {
 func Delete(key string) error {
 	res := gClient.Do("DEL", key)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Delete"), zap.Error(res.Err()))
 		return res.Err()
 	}
 
 	_, err := res.Int64()
 	if err != nil {
-		log.Println(err)
+		zap.L().Error("redis command failed", zap.String("func", "Delete"), zap.Error(err))
 		return err
 	}
 
@@ -88,7 +87,7 @@ func Delete(key string) error {
 func SetExpire(key string, time int) error {
 	res := gClient.Do("expire", key, time)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "SetExpire"), zap.Error(res.Err()))
 		return res.Err()
 	}
 	return nil
@@ -98,7 +97,7 @@ func SetExpire(key string, time int) error {
 func HashSet(keyValue ...string) error {
 	res := gClient.Do("HSET", keyValue[0], keyValue[1], keyValue[2])
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "HashSet"), zap.Error(res.Err()))
 		return res.Err()
 	}
 	return nil
@@ -107,7 +106,7 @@ func HashSet(keyValue ...string) error {
 func HashMSet(key string, keyValue ...string) error {
 	res := gClient.HMSet(key, keyValue)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "HashMSet"), zap.Error(res.Err()))
 		return res.Err()
 	}
 	return nil
@@ -116,13 +115,13 @@ func HashMSet(key string, keyValue ...string) error {
 func HashGet(keyValue ...string) (string, error) {
 	res := gClient.Do("HGET", keyValue[0], keyValue[1])
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "HashGet"), zap.Error(res.Err()))
 		return "", res.Err()
 	}
 
 	value, err := res.Text()
 	if err != nil {
-		log.Println(err)
+		zap.L().Error("redis command failed", zap.String("func", "HashGet"), zap.Error(err))
 		return "", err
 	}
 	return value, nil
@@ -131,7 +130,7 @@ func HashGet(keyValue ...string) (string, error) {
 func HGetAll(key string) (map[string]string, error) {
 	res := gClient.HGetAll(key)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "HGetAll"), zap.Error(res.Err()))
 		return nil, res.Err()
 	}
 
@@ -141,7 +140,7 @@ func HGetAll(key string) (map[string]string, error) {
 func LIndex(key string, index int64) (string, error) {
 	stringCmd := gClient.LIndex(key, index)
 	if stringCmd.Err() != nil {
-		log.Println(stringCmd.Err())
+		zap.L().Error("redis command failed", zap.String("func", "LIndex"), zap.Error(stringCmd.Err()))
 		return "", stringCmd.Err()
 	}
 
@@ -151,7 +150,7 @@ func LIndex(key string, index int64) (string, error) {
 func Brpop(queueName string, timeout int) (data string, err error) {
 	res := gClient.Do("brpop", queueName, timeout)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Brpop"), zap.Error(res.Err()))
 		return "", res.Err()
 	}
 	return data, nil
@@ -160,7 +159,7 @@ func Brpop(queueName string, timeout int) (data string, err error) {
 func Lpush(keyValue ...string) (err error) {
 	res := gClient.Do("lpush", keyValue[0], keyValue[1])
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Lpush"), zap.Error(res.Err()))
 		return res.Err()
 	}
 	return
@@ -169,7 +168,7 @@ func Lpush(keyValue ...string) (err error) {
 func Llen(key string) (len int, err error) {
 	res := gClient.Do("llen", key)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Llen"), zap.Error(res.Err()))
 		return 0, res.Err()
 	}
 	return res.Int()
@@ -178,7 +177,7 @@ func Llen(key string) (len int, err error) {
 func Hincrby(hash string, field string, incr int) (err error) {
 	res := gClient.Do("HINCRBY", hash, field, incr)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Hincrby"), zap.Error(res.Err()))
 		return res.Err()
 	}
 	return
@@ -187,7 +186,7 @@ func Hincrby(hash string, field string, incr int) (err error) {
 func Brpoplpush(source string, destination string, timeout time.Duration) (data string, err error) {
 	res := gClient.BRPopLPush(source, destination, timeout)
 	if res.Err() != nil {
-		log.Println(res.Err())
+		zap.L().Error("redis command failed", zap.String("func", "Brpoplpush"), zap.Error(res.Err()))
 		return "", res.Err()
 	}
 	return res.Val(), nil
@@ -229,7 +228,7 @@ func Init(address string) error {
 
 		//钩子函数
 		OnConnect: func(conn *redis.Conn) error { //仅当客户端执行命令时需要从连接池获取连接时，如果连接池需要新建连接时则会调用此钩子函数
-			fmt.Printf("conn=%v\n", conn)
+			zap.L().Debug("redis connection opened", zap.String("address", address))
 			return nil
 		},
 	})
@@ -6,7 +6,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
+	"go.uber.org/zap"
 )
 
 var (
@@ -48,9 +48,9 @@ func HandleCrash(additionalHandlers ...func(interface{})) {
 func logPanic(r interface{}) {
 	callers := getCallers(r)
 	if _, ok := r.(string); ok {
-		glog.Errorf("Observed a panic: %s\n%v", r, callers)
+		zap.L().Error(fmt.Sprintf("Observed a panic: %s", r), zap.String("callers", callers))
 	} else {
-		glog.Errorf("Observed a panic: %#v (%v)\n%v", r, r, callers)
+		zap.L().Error(fmt.Sprintf("Observed a panic: %#v (%v)", r, r), zap.String("callers", callers))
 	}
 }
 
@@ -99,7 +99,7 @@ func HandleError(err error) {
 
 // logError prints an error with the call stack of the location it was reported
 func logError(err error) {
-	glog.ErrorDepth(2, err)
+	zap.L().WithOptions(zap.AddCallerSkip(2)).Error(err.Error())
 }
 
 type rudimentaryErrorBackoff struct {
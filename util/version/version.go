@@ -0,0 +1,5 @@
+package version
+
+// Version is the version of heque. It is overridden at build time with
+// -ldflags="-X denggotech.cn/heque/heque/util/version.Version=...".
+var Version = "v0.0.0-dev"
@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/client"
+)
+
+const (
+	// DefaultHeartbeatInterval is how often a worker refreshes its registration.
+	DefaultHeartbeatInterval = 10 * time.Second
+	// DefaultHeartbeatTTL is how long a registration outlives the last heartbeat.
+	DefaultHeartbeatTTL = 30 * time.Second
+	// DefaultPollTimeout is how long a dequeue blocks before the worker checks
+	// whether it should stop.
+	DefaultPollTimeout = 5 * time.Second
+	// DefaultTimeoutGracePeriod is how long a handler is waited for once its
+	// job has timed out.
+	DefaultTimeoutGracePeriod = 10 * time.Second
+)
+
+// Config is a structure used to configure a Worker.
+type Config struct {
+	// Client is the heque client used to consume jobs.
+	Client *client.Client
+	// QueueName is the name of the queue consumed by the worker.
+	QueueName string
+	// HeartbeatInterval is how often the worker refreshes its registration.
+	HeartbeatInterval time.Duration
+	// HeartbeatTTL is how long the registration outlives the last heartbeat.
+	HeartbeatTTL time.Duration
+	// PollTimeout bounds how long a dequeue blocks.
+	PollTimeout time.Duration
+	// DefaultTimeout bounds the execution of the jobs which do not specify
+	// their own timeout. Zero means no timeout.
+	DefaultTimeout time.Duration
+	// TimeoutGracePeriod is how long the handler of a job which timed out
+	// is waited for, after its context is cancelled, before the worker
+	// moves on to the next job. The job is retried or failed only once the
+	// handler has returned.
+	TimeoutGracePeriod time.Duration
+	// BindAddress is the address (host:port) on which the health, readiness,
+	// metrics and debug endpoints are served. If empty, they are not served.
+	BindAddress string
+	// ReadyChecks are run by /readyz, in addition to checking redis.
+	ReadyChecks []ReadyCheck
+	// Logger is the logger of the worker. If nil, the global logger is used.
+	Logger *zap.Logger
+}
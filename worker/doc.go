@@ -0,0 +1,4 @@
+// Package worker contains the runtime shared by heque workers: the dequeue
+// loop, the heartbeat that registers the worker in redis and the reaping of
+// jobs held by dead workers.
+package worker
@@ -0,0 +1,3 @@
+// Package filters contains the job handler chain filters shared by the
+// heque workers, analogous to the http filters of the apiserver.
+package filters
@@ -0,0 +1,62 @@
+package filters
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/util/logging"
+	"denggotech.cn/heque/heque/worker"
+)
+
+// redactedFields are the payload fields never written to the logs.
+var redactedFields = []string{"token", "accessKey", "password"}
+
+// WithLogging wraps a job Handler to log the start and the end of every job.
+// The payload is logged at debug level, with its secrets redacted.
+func WithLogging(handler worker.Handler) worker.Handler {
+	return worker.HandlerFunc(func(ctx context.Context, job *client.Job) error {
+		logger := logging.FromContext(ctx)
+		logger.Info("job started")
+		if ce := logger.Check(zap.DebugLevel, "job payload"); ce != nil {
+			ce.Write(zap.String("payload", RedactPayload(job.Spec.Payload)))
+		}
+
+		start := time.Now()
+		err := handler.Handle(ctx, job)
+		if err != nil {
+			logger.Error("job failed", zap.Duration("duration", time.Since(start)), zap.Error(err))
+		} else {
+			logger.Info("job done", zap.Duration("duration", time.Since(start)))
+		}
+		return err
+	})
+}
+
+// RedactPayload returns the JSON payload with the values of secret fields,
+// such as the debtdb token, replaced. A payload which is not a JSON object is
+// redacted altogether.
+func RedactPayload(payload string) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return "[REDACTED]"
+	}
+
+	for k := range fields {
+		for _, f := range redactedFields {
+			if strings.EqualFold(k, f) {
+				fields[k] = "[REDACTED]"
+			}
+		}
+	}
+
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		return "[REDACTED]"
+	}
+	return string(buf)
+}
@@ -0,0 +1,72 @@
+package filters
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/worker"
+)
+
+// MetricsRecorder records the outcome of the jobs processed by a worker.
+type MetricsRecorder interface {
+	// JobStarted is called before the job is handed to the handler.
+	JobStarted(job *client.Job)
+	// JobFinished is called after the handler returned err.
+	JobFinished(job *client.Job, duration time.Duration, err error)
+}
+
+// Metrics returns a Middleware wrapping a job Handler to report every job to
+// recorder.
+func Metrics(recorder MetricsRecorder) worker.Middleware {
+	return func(handler worker.Handler) worker.Handler {
+		return worker.HandlerFunc(func(ctx context.Context, job *client.Job) error {
+			recorder.JobStarted(job)
+
+			start := time.Now()
+			err := handler.Handle(ctx, job)
+			recorder.JobFinished(job, time.Since(start), err)
+			return err
+		})
+	}
+}
+
+var (
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "heque_job_duration_seconds",
+		Help:    "Time spent by the handler on a job, by result.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"queue", "result"})
+	jobsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "heque_jobs_in_flight",
+		Help: "Number of jobs being handled by this worker.",
+	}, []string{"queue"})
+)
+
+func init() {
+	prometheus.MustRegister(jobDuration, jobsInFlight)
+}
+
+// prometheusRecorder records jobs in the Prometheus metrics of the worker.
+type prometheusRecorder struct{}
+
+func (prometheusRecorder) JobStarted(job *client.Job) {
+	jobsInFlight.WithLabelValues(job.Spec.QueueName).Inc()
+}
+
+func (prometheusRecorder) JobFinished(job *client.Job, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	jobsInFlight.WithLabelValues(job.Spec.QueueName).Dec()
+	jobDuration.WithLabelValues(job.Spec.QueueName, result).Observe(duration.Seconds())
+}
+
+// WithPrometheusMetrics wraps a job Handler to record every job in the
+// Prometheus metrics served on /metrics by the worker.
+func WithPrometheusMetrics(handler worker.Handler) worker.Handler {
+	return Metrics(prometheusRecorder{})(handler)
+}
@@ -0,0 +1,30 @@
+package filters
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/util/logging"
+	utilruntime "denggotech.cn/heque/heque/util/runtime"
+	"denggotech.cn/heque/heque/worker"
+)
+
+// WithPanicRecovery wraps a job Handler to recover panics and turn them into
+// errors, so that the job is marked as failed instead of crashing the worker.
+func WithPanicRecovery(handler worker.Handler) worker.Handler {
+	return worker.HandlerFunc(func(ctx context.Context, job *client.Job) (err error) {
+		panicked := true
+		defer func() {
+			if panicked {
+				logging.FromContext(ctx).Error("handler panic'd", zap.Error(err))
+			}
+		}()
+		defer utilruntime.RecoverFromPanic(&err)
+		// Dispatch to the internal handler
+		err = handler.Handle(ctx, job)
+		panicked = false
+		return err
+	})
+}
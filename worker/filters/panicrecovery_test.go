@@ -0,0 +1,32 @@
+package filters
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/worker"
+)
+
+func TestWithPanicRecovery(t *testing.T) {
+	handler := worker.Chain(worker.HandlerFunc(func(ctx context.Context, job *client.Job) error {
+		panic("test")
+	}), WithTiming, WithPanicRecovery)
+
+	job := &client.Job{ID: "1"}
+	err := handler.Handle(context.Background(), job)
+	if err == nil || !strings.Contains(err.Error(), "recovered from panic") {
+		t.Errorf("expected the panic to be returned as an error, got %v", err)
+	}
+	if job.Status.CompletionTime == nil {
+		t.Errorf("expected the completion time to be recorded")
+	}
+}
+
+func TestRedactPayload(t *testing.T) {
+	got := RedactPayload(`{"PkgID":"1","Token":"secret"}`)
+	if strings.Contains(got, "secret") || !strings.Contains(got, `"PkgID":"1"`) {
+		t.Errorf("unexpected redacted payload %s", got)
+	}
+}
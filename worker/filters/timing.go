@@ -0,0 +1,24 @@
+package filters
+
+import (
+	"context"
+	"time"
+
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/worker"
+)
+
+// WithTiming wraps a job Handler to record the start and completion time of
+// the job in its status.
+func WithTiming(handler worker.Handler) worker.Handler {
+	return worker.HandlerFunc(func(ctx context.Context, job *client.Job) error {
+		start := time.Now()
+		job.Status.StartTime = &start
+
+		err := handler.Handle(ctx, job)
+
+		completion := time.Now()
+		job.Status.CompletionTime = &completion
+		return err
+	})
+}
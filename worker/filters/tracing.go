@@ -0,0 +1,44 @@
+package filters
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/util/tracing"
+	"denggotech.cn/heque/heque/worker"
+)
+
+// WithTracing wraps a job Handler to trace every job. The span of the job
+// continues the trace of the caller of EnqueueContext and is preceded by a
+// span covering the time the job waited in the queue. Outgoing requests made
+// with the context of the handler become children of the job span.
+func WithTracing(handler worker.Handler) worker.Handler {
+	return worker.HandlerFunc(func(ctx context.Context, job *client.Job) error {
+		ctx = tracing.Extract(ctx, job.Spec.TraceContext)
+		attrs := trace.WithAttributes(
+			attribute.String("heque.job.id", job.ID),
+			attribute.String("heque.queue", job.Spec.QueueName),
+			attribute.String("heque.batch", job.Spec.Batch),
+			attribute.Int("heque.attempt", job.Status.Attempts),
+		)
+
+		if job.Status.EnqueueTime != nil {
+			_, wait := tracing.Tracer().Start(ctx, "heque.queue_wait", attrs, trace.WithTimestamp(*job.Status.EnqueueTime))
+			wait.End()
+		}
+
+		ctx, span := tracing.Tracer().Start(ctx, "heque.job "+job.Spec.QueueName, attrs, trace.WithSpanKind(trace.SpanKindConsumer))
+		defer span.End()
+
+		err := handler.Handle(ctx, job)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	})
+}
@@ -0,0 +1,143 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/client"
+	utilhttp "denggotech.cn/heque/heque/util/http"
+	utilruntime "denggotech.cn/heque/heque/util/runtime"
+)
+
+// ReadyCheck is a named check run by /readyz, e.g. that a downstream service
+// is configured.
+type ReadyCheck struct {
+	Name  string
+	Check func() error
+}
+
+// jobInfo is the entry of a job in /debug/jobs.
+type jobInfo struct {
+	ID        string    `json:"id"`
+	QueueName string    `json:"queueName"`
+	Batch     string    `json:"batch"`
+	Attempts  int       `json:"attempts"`
+	StartTime time.Time `json:"startTime"`
+}
+
+// serve starts the admin HTTP server on the bind address of the worker and
+// shuts it down when stopCh is closed.
+func (w *Worker) serve(stopCh <-chan struct{}) error {
+	ln, err := net.Listen("tcp", w.cfg.BindAddress)
+	if err != nil {
+		return err
+	}
+
+	utilruntime.HandleError(client.RegisterMetrics(prometheus.DefaultRegisterer))
+	srv := &http.Server{Handler: w.adminHandler()}
+
+	w.logger.Info("serving HTTP", zap.String("address", "http://"+w.cfg.BindAddress))
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			utilruntime.HandleError(err)
+		}
+	}()
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		utilruntime.HandleError(srv.Shutdown(ctx))
+	}()
+	return nil
+}
+
+func (w *Worker) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "ok")
+	})
+	mux.HandleFunc("/readyz", w.readyz)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/debug/jobs", w.debugJobs)
+	return mux
+}
+
+// readyz reports whether redis is reachable and every ReadyCheck passes.
+func (w *Worker) readyz(rw http.ResponseWriter, r *http.Request) {
+	checks := append([]ReadyCheck{{Name: "redis", Check: w.cfg.Client.Ping}}, w.cfg.ReadyChecks...)
+
+	var out bytes.Buffer
+	failed := false
+	for _, check := range checks {
+		if err := check.Check(); err != nil {
+			fmt.Fprintf(&out, "[-]%s failed: %s\n", check.Name, err)
+			failed = true
+		} else {
+			fmt.Fprintf(&out, "[+]%s ok\n", check.Name)
+		}
+	}
+
+	if failed {
+		http.Error(rw, out.String()+"readyz check failed", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(rw, "ok")
+}
+
+// debugJobs lists the jobs in progress.
+func (w *Worker) debugJobs(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	jobs := make([]jobInfo, 0, len(w.jobs))
+	for id, job := range w.jobs {
+		jobs = append(jobs, jobInfo{
+			ID:        id,
+			QueueName: job.Spec.QueueName,
+			Batch:     job.Spec.Batch,
+			Attempts:  job.Status.Attempts,
+			StartTime: w.started[id],
+		})
+	}
+	w.mu.Unlock()
+
+	utilruntime.HandleError(utilhttp.JSON(rw, jobs, http.StatusOK))
+}
+
+// readyCheckTimeout bounds the connection of a URLReadyCheck.
+const readyCheckTimeout = 2 * time.Second
+
+// URLReadyCheck returns a ReadyCheck verifying that the address of a
+// downstream service is an absolute http(s) URL and that the service
+// accepts connections.
+func URLReadyCheck(name string, rawurl string) ReadyCheck {
+	return ReadyCheck{
+		Name: name,
+		Check: func() error {
+			u, err := url.Parse(rawurl)
+			if err != nil {
+				return err
+			}
+			if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%q is not an http(s) URL", rawurl)
+			}
+
+			addr := u.Host
+			if u.Port() == "" {
+				addr = net.JoinHostPort(u.Hostname(), u.Scheme)
+			}
+			conn, err := net.DialTimeout("tcp", addr, readyCheckTimeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+	}
+}
@@ -0,0 +1,314 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"denggotech.cn/heque/heque/client"
+	"denggotech.cn/heque/heque/util/logging"
+	utilruntime "denggotech.cn/heque/heque/util/runtime"
+	"denggotech.cn/heque/heque/util/uuid"
+	"denggotech.cn/heque/heque/util/version"
+)
+
+// Handler processes a job dequeued by a Worker. A nil error marks the job as
+// done, any other error marks it as failed.
+type Handler interface {
+	Handle(ctx context.Context, job *client.Job) error
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(ctx context.Context, job *client.Job) error
+
+// Handle calls f(ctx, job).
+func (f HandlerFunc) Handle(ctx context.Context, job *client.Job) error {
+	return f(ctx, job)
+}
+
+// Middleware wraps a Handler with a cross-cutting concern, the way the
+// apiserver filters wrap an http.Handler.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares. The first middleware is the outermost
+// one, i.e. it sees the job first and the error last.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Worker dequeues jobs from a queue and hands them to a Handler. While it
+// runs it keeps itself registered in redis with a periodic heartbeat.
+type Worker struct {
+	cfg     *Config
+	handler Handler
+	info    *client.WorkerInfo
+	logger  *zap.Logger
+
+	mu      sync.Mutex
+	jobs    map[string]*client.Job
+	started map[string]time.Time
+	// overrunning tracks the handlers still running after the grace period
+	// of their timeout.
+	overrunning sync.WaitGroup
+}
+
+// NewWorker creates and initializes a new Worker object.
+func NewWorker(cfg *Config, handler Handler) *Worker {
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.HeartbeatTTL == 0 {
+		cfg.HeartbeatTTL = DefaultHeartbeatTTL
+	}
+	if cfg.PollTimeout == 0 {
+		cfg.PollTimeout = DefaultPollTimeout
+	}
+	if cfg.TimeoutGracePeriod == 0 {
+		cfg.TimeoutGracePeriod = DefaultTimeoutGracePeriod
+	}
+
+	hostname, _ := os.Hostname()
+	id := fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.NewUUID()[:8])
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = zap.L()
+	}
+
+	return &Worker{
+		cfg:     cfg,
+		handler: handler,
+		logger:  logger.With(zap.String("worker", id)),
+		info: &client.WorkerInfo{
+			ID:        id,
+			Hostname:  hostname,
+			PID:       os.Getpid(),
+			Queues:    []string{cfg.QueueName},
+			Version:   version.Version,
+			StartTime: time.Now(),
+		},
+		jobs:    make(map[string]*client.Job),
+		started: make(map[string]time.Time),
+	}
+}
+
+// ID returns the ID under which the worker is registered.
+func (w *Worker) ID() string {
+	return w.info.ID
+}
+
+// Run consumes jobs until stopCh is closed. The job in progress, if any, is
+// finished before Run unregisters the worker and returns. If the worker has
+// a bind address, Run also serves the health, readiness, metrics and debug
+// endpoints there.
+func (w *Worker) Run(stopCh <-chan struct{}) error {
+	if w.cfg.BindAddress != "" {
+		if err := w.serve(stopCh); err != nil {
+			return err
+		}
+	}
+
+	if err := w.heartbeat(); err != nil {
+		return err
+	}
+	w.logger.Info("worker registered", zap.String("queue", w.cfg.QueueName))
+
+	done := make(chan struct{})
+	defer close(done)
+	go w.heartbeatLoop(done)
+
+	for {
+		select {
+		case <-stopCh:
+			w.logger.Info("worker stopping")
+			// the jobs of the handlers still running after their timeout
+			// are released once they return
+			w.overrunning.Wait()
+			return w.cfg.Client.Unregister(w.info.ID)
+		default:
+		}
+
+		job, err := w.cfg.Client.DequeueTimeout(w.cfg.QueueName, w.cfg.PollTimeout)
+		if err == client.ErrNoAvailableJob {
+			continue
+		}
+		if err != nil {
+			utilruntime.HandleError(err)
+			select {
+			case <-stopCh:
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		w.process(job)
+	}
+}
+
+// Jobs returns the jobs currently being processed by the worker.
+func (w *Worker) Jobs() []*client.Job {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	jobs := make([]*client.Job, 0, len(w.jobs))
+	for _, job := range w.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// process runs the handler on job and then acknowledges, retries or fails
+// it. The job is released only once its handler has returned, or it could
+// run twice at the same time.
+func (w *Worker) process(job *client.Job) {
+	w.track(job)
+
+	logger := w.jobLogger(job)
+	handled, errCh, err := w.handle(logging.NewContext(context.Background(), logger), job)
+	if errCh == nil {
+		w.finish(handled, err)
+		return
+	}
+
+	// the context of the handler is cancelled, give it some time to return
+	select {
+	case <-errCh:
+		w.finish(handled, context.DeadlineExceeded)
+		return
+	case <-time.After(w.cfg.TimeoutGracePeriod):
+	}
+
+	// the job stays held by the worker until the handler returns
+	logger.Error("job handler still running after its timeout, moving on to the next job",
+		zap.Duration("gracePeriod", w.cfg.TimeoutGracePeriod))
+	w.overrunning.Add(1)
+	go func() {
+		defer w.overrunning.Done()
+		<-errCh
+		logger.Info("job handler returned after its timeout")
+		w.finish(handled, context.DeadlineExceeded)
+	}()
+}
+
+// finish acknowledges job if err is nil, or else retries or fails it, and
+// stops tracking it.
+func (w *Worker) finish(job *client.Job, err error) {
+	defer w.untrack(job)
+
+	switch {
+	case err == nil:
+		err = w.cfg.Client.MarkAsDone(job)
+	case job.Status.Attempts <= job.Spec.MaxRetries:
+		w.jobLogger(job).Info("retrying job", zap.Int("maxAttempts", job.Spec.MaxRetries+1), zap.Error(err))
+		err = w.cfg.Client.Retry(job)
+	default:
+		err = w.cfg.Client.MarkAsFailed(job)
+	}
+	utilruntime.HandleError(err)
+}
+
+// jobLogger returns the logger of the worker with the fields of job. The
+// handler gets it from its context, see logging.FromContext.
+func (w *Worker) jobLogger(job *client.Job) *zap.Logger {
+	return w.logger.With(
+		zap.String("job", job.ID),
+		zap.String("queue", job.Spec.QueueName),
+		zap.String("batch", job.Spec.Batch),
+		zap.Int("attempt", job.Status.Attempts),
+	)
+}
+
+// handle runs the handler on a copy of job with the deadline of the job, and
+// returns the copy and the error of the handler. If the handler is still
+// running at the deadline, its context is cancelled and the channel which
+// will receive its error is returned instead.
+func (w *Worker) handle(ctx context.Context, job *client.Job) (*client.Job, <-chan error, error) {
+	timeout := job.Spec.Timeout
+	if timeout == 0 {
+		timeout = w.cfg.DefaultTimeout
+	}
+
+	cancel := func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	// the handler does not share the job with the worker while it runs
+	handled := *job
+	errCh := make(chan error, 1)
+	go func() {
+		defer cancel()
+		errCh <- w.handler.Handle(ctx, &handled)
+	}()
+
+	select {
+	case err := <-errCh:
+		return &handled, nil, err
+	case <-ctx.Done():
+		cancel()
+		logging.FromContext(ctx).Error("job exceeded its timeout", zap.Duration("timeout", timeout))
+		return &handled, errCh, ctx.Err()
+	}
+}
+
+func (w *Worker) track(job *client.Job) {
+	w.mu.Lock()
+	w.jobs[job.ID] = job
+	w.started[job.ID] = time.Now()
+	w.mu.Unlock()
+
+	// record the ownership right away so that the job can be requeued if
+	// this process dies before the next tick.
+	utilruntime.HandleError(w.heartbeat())
+}
+
+func (w *Worker) untrack(job *client.Job) {
+	w.mu.Lock()
+	delete(w.jobs, job.ID)
+	delete(w.started, job.ID)
+	w.mu.Unlock()
+
+	utilruntime.HandleError(w.heartbeat())
+}
+
+func (w *Worker) heartbeat() error {
+	w.mu.Lock()
+	jobs := make(map[string]string, len(w.jobs))
+	for id, job := range w.jobs {
+		jobs[id] = job.Spec.QueueName
+	}
+	info := *w.info
+	info.Jobs = jobs
+	w.mu.Unlock()
+
+	return w.cfg.Client.Heartbeat(&info, w.cfg.HeartbeatTTL)
+}
+
+func (w *Worker) heartbeatLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(w.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		utilruntime.HandleError(w.heartbeat())
+
+		n, err := w.cfg.Client.ReapDeadWorkers()
+		if err != nil {
+			utilruntime.HandleError(err)
+		} else if n > 0 {
+			w.logger.Info("requeued jobs of dead workers", zap.Int("jobs", n))
+		}
+	}
+}